@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// remoteURL returns the fetch URL configured for remote.
+func remoteURL(remote string) (string, error) {
+	output, err := git.Output("remote", "get-url", remote)
+	if err != nil {
+		return "", fmt.Errorf("no URL for remote %s", remote)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// webRepo turns a remote URL in any of the usual SSH or HTTPS forms into
+// the host and owner/repo path of its web page, e.g.
+// git@github.com:owner/repo.git -> github.com, owner/repo.
+func webRepo(remote string) (host, path string, ok bool) {
+	if u, err := url.Parse(remote); err == nil && u.Host != "" {
+		// https://github.com/owner/repo.git, ssh://git@host:22/owner/repo
+		host, path = u.Hostname(), u.Path
+	} else if at, rest, found := strings.Cut(remote, ":"); found && !strings.Contains(at, "/") {
+		// scp-like syntax: git@github.com:owner/repo.git
+		host = at[strings.LastIndex(at, "@")+1:]
+		path = rest
+	} else {
+		return "", "", false
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	return host, path, host != "" && path != ""
+}
+
+// pullRequestURL returns the page for opening a pull (or merge) request
+// from branch on the repository remote points to. Only GitHub and GitLab
+// hosts are recognized.
+func pullRequestURL(remote, branch string) (string, error) {
+	host, path, ok := webRepo(remote)
+	if !ok {
+		return "", fmt.Errorf("can't parse remote URL %s", remote)
+	}
+	switch {
+	case strings.Contains(host, "github"):
+		ref := strings.ReplaceAll(url.PathEscape(branch), "%2F", "/")
+		return fmt.Sprintf("https://%s/%s/compare/%s?expand=1", host, path, ref), nil
+	case strings.Contains(host, "gitlab"):
+		return fmt.Sprintf("https://%s/%s/-/merge_requests/new?merge_request%%5Bsource_branch%%5D=%s", host, path, url.QueryEscape(branch)), nil
+	}
+	return "", fmt.Errorf("don't know how to open pull requests on %s", host)
+}
+
+// openBrowser opens link in the default web browser.
+func openBrowser(link string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", link)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", link)
+	default:
+		cmd = exec.Command("xdg-open", link)
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboardCommand returns the command used to write to the system
+// clipboard on this platform.
+func clipboardCommand() (*exec.Cmd, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("pbcopy"), nil
+	case "windows":
+		return exec.Command("clip.exe"), nil
+	}
+
+	candidates := [][]string{
+		{"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"},
+		{"clip.exe"}, // WSL
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		candidates = append([][]string{{"wl-copy"}}, candidates...)
+	}
+	for _, c := range candidates {
+		if _, err := exec.LookPath(c[0]); err == nil {
+			return exec.Command(c[0], c[1:]...), nil
+		}
+	}
+	return nil, errors.New("no clipboard tool found (install xclip, xsel or wl-clipboard)")
+}
+
+// copyToClipboard writes text to the system clipboard.
+func copyToClipboard(text string) error {
+	cmd, err := clipboardCommand()
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config holds defaults read from the config file. Command-line flags
+// override anything set here, and the merged result configures the model.
+type config struct {
+	remote         bool
+	all            bool
+	remoteName     string // only list this remote's branches
+	fetch          bool
+	height         int
+	limit          int
+	sort           string
+	base           string // branch merges are checked against, detected if empty
+	dateFormat     string
+	exclude        []string
+	mine           bool                // only list branches whose tip commit is the user's
+	group          bool                // list branches under a header per prefix
+	tags           bool                // list tags alongside branches
+	email          string              // the user's email, looked up for mine
+	pins           map[string][]string // pinned branches keyed by repo root
+	theme          string
+	highlightRow   bool // fill the highlighted row with the theme's background
+	selectOnly     bool
+	dryRun         bool
+	worktree       bool
+	rememberFilter bool          // restore the filter last applied in the same repo
+	liveFilter     bool          // filter as you type instead of after pressing /
+	stashPop       bool          // pop the stash made from the picker after checkout
+	inline         bool          // render below the prompt rather than on the alternate screen
+	compact        bool          // leave out everything but branch names and dates
+	format         string        // row template, see parseRowFormat
+	timeout        time.Duration // how long any git command may take
+	agingDays      int           // days without commits before a date turns yellow
+	staleDays      int           // days without commits before a date turns red
+	keys           keyMap
+}
+
+func defaultConfig() config {
+	return config{
+		limit:      50,
+		sort:       "-date",
+		dateFormat: "relative",
+		theme:      "default",
+		pins:       map[string][]string{},
+		keys:       defaultKeyMap(),
+		timeout:    30 * time.Second,
+		agingDays:  7,
+		staleDays:  30,
+	}
+}
+
+// query returns the branch listing options selected by cfg.
+func (cfg config) query() branchQuery {
+	return branchQuery{
+		remote:     cfg.remote,
+		all:        cfg.all,
+		remoteName: cfg.remoteName,
+		limit:      cfg.limit,
+		sort:       cfg.sort,
+		exclude:    cfg.exclude,
+		email:      cfg.email,
+		tags:       cfg.tags,
+		dates:      cfg.dateFormat,
+		base:       cfg.base,
+	}
+}
+
+// configPath returns the location of the config file, honouring
+// XDG_CONFIG_HOME and falling back to ~/.config.
+func configPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "git-recent", "config.toml")
+}
+
+// loadConfig reads the config file. A missing or malformed file silently
+// yields the built-in defaults.
+func loadConfig() config {
+	path := configPath()
+	if path == "" {
+		return defaultConfig()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return defaultConfig()
+	}
+	cfg, err := parseConfig(string(data))
+	if err != nil {
+		return defaultConfig()
+	}
+	return cfg
+}
+
+// parseConfig reads the small subset of TOML the config file needs:
+// comments, [section] headers and key = value pairs. Unknown keys are
+// ignored so older builds can read newer files.
+func parseConfig(data string) (config, error) {
+	cfg := defaultConfig()
+	section := ""
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, err := splitKeyValue(line)
+		if err != nil {
+			return cfg, fmt.Errorf("line %d: %v", i+1, err)
+		}
+
+		switch section {
+		case "":
+			err = cfg.set(key, value)
+		case "pins":
+			cfg.pins[key], err = parseStringArray(value)
+		case "keys":
+			var keys []string
+			if strings.HasPrefix(value, "[") {
+				keys, err = parseStringArray(value)
+			} else {
+				var k string
+				k, err = parseString(value)
+				keys = []string{k}
+			}
+			if err == nil {
+				cfg.keys.set(key, keys)
+			}
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("line %d: invalid value for %s: %v", i+1, key, err)
+		}
+	}
+	return cfg, nil
+}
+
+// set applies a top-level config key.
+func (cfg *config) set(key, value string) error {
+	var err error
+	switch key {
+	case "remote":
+		cfg.remote, err = strconv.ParseBool(value)
+	case "all":
+		cfg.all, err = strconv.ParseBool(value)
+	case "remote_name":
+		cfg.remoteName, err = parseString(value)
+	case "fetch":
+		cfg.fetch, err = strconv.ParseBool(value)
+	case "height":
+		cfg.height, err = strconv.Atoi(value)
+	case "limit":
+		cfg.limit, err = strconv.Atoi(value)
+	case "theme":
+		cfg.theme, err = parseString(value)
+		if _, ok := themes()[cfg.theme]; err == nil && !ok {
+			err = fmt.Errorf("unknown theme %q", cfg.theme)
+		}
+	case "highlight_row":
+		cfg.highlightRow, err = strconv.ParseBool(value)
+	case "tags":
+		cfg.tags, err = strconv.ParseBool(value)
+	case "group":
+		cfg.group, err = strconv.ParseBool(value)
+	case "mine":
+		cfg.mine, err = strconv.ParseBool(value)
+	case "exclude":
+		cfg.exclude, err = parseStringArray(value)
+	case "date_format":
+		cfg.dateFormat, err = parseString(value)
+		if _, ok := dateFormats[cfg.dateFormat]; err == nil && !ok {
+			err = fmt.Errorf("unknown date format %q", cfg.dateFormat)
+		}
+	case "timeout":
+		var d string
+		if d, err = parseString(value); err == nil {
+			cfg.timeout, err = time.ParseDuration(d)
+		}
+	case "aging_days":
+		cfg.agingDays, err = strconv.Atoi(value)
+	case "stale_days":
+		cfg.staleDays, err = strconv.Atoi(value)
+	case "inline":
+		cfg.inline, err = strconv.ParseBool(value)
+	case "compact":
+		cfg.compact, err = strconv.ParseBool(value)
+	case "format":
+		cfg.format, err = parseString(value)
+		if err == nil {
+			_, err = parseRowFormat(cfg.format)
+		}
+	case "stash_pop":
+		cfg.stashPop, err = strconv.ParseBool(value)
+	case "live_filter":
+		cfg.liveFilter, err = strconv.ParseBool(value)
+	case "remember_filter":
+		cfg.rememberFilter, err = strconv.ParseBool(value)
+	case "base":
+		cfg.base, err = parseString(value)
+	case "sort":
+		cfg.sort, err = parseString(value)
+		if _, ok := sortKeys[cfg.sort]; err == nil && !ok {
+			err = fmt.Errorf("unknown sort order %q", cfg.sort)
+		}
+	}
+	return err
+}
+
+// splitKeyValue splits a key = value line. Keys may be bare or quoted.
+func splitKeyValue(line string) (key, value string, err error) {
+	if strings.HasPrefix(line, `"`) {
+		quoted, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return "", "", err
+		}
+		key, _ = strconv.Unquote(quoted)
+		line = strings.TrimSpace(line[len(quoted):])
+		if !strings.HasPrefix(line, "=") {
+			return "", "", fmt.Errorf("expected key = value")
+		}
+		return key, strings.TrimSpace(line[1:]), nil
+	}
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return "", "", fmt.Errorf("expected key = value")
+	}
+	return strings.TrimSpace(key), strings.TrimSpace(value), nil
+}
+
+// savePins records the pinned branches for repo in the [pins] section of
+// the config file, leaving the rest of the file as it was.
+func savePins(repo string, pins []string) error {
+	path := configPath()
+	if path == "" {
+		return fmt.Errorf("no config directory")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Keep everything outside [pins], collecting the existing entries
+	all := map[string][]string{}
+	var kept []string
+	section := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+		}
+		if section != "pins" {
+			kept = append(kept, line)
+			continue
+		}
+		if key, value, err := splitKeyValue(trimmed); err == nil {
+			if items, err := parseStringArray(value); err == nil {
+				all[key] = items
+			}
+		}
+	}
+
+	if len(pins) > 0 {
+		all[repo] = pins
+	} else {
+		delete(all, repo)
+	}
+
+	out := strings.TrimRight(strings.Join(kept, "\n"), "\n")
+	if len(all) > 0 {
+		repos := make([]string, 0, len(all))
+		for r := range all {
+			repos = append(repos, r)
+		}
+		sort.Strings(repos)
+		if out != "" {
+			out += "\n\n"
+		}
+		out += "[pins]\n"
+		for _, r := range repos {
+			quoted := make([]string, len(all[r]))
+			for i, p := range all[r] {
+				quoted[i] = strconv.Quote(p)
+			}
+			out += fmt.Sprintf("%s = [%s]\n", strconv.Quote(r), strings.Join(quoted, ", "))
+		}
+	} else if out != "" {
+		out += "\n"
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(out), 0o644)
+}
+
+// statePath returns the location of the state file, honouring
+// XDG_STATE_HOME and falling back to ~/.local/state.
+func statePath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "git-recent", "state.toml")
+}
+
+// loadFilters reads the last applied filter for each repo from the
+// [filters] section of the state file. Unreadable entries are skipped.
+func loadFilters() map[string]string {
+	filters := map[string]string{}
+	path := statePath()
+	if path == "" {
+		return filters
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return filters
+	}
+	section := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != "filters" {
+			continue
+		}
+		if key, value, err := splitKeyValue(line); err == nil {
+			if text, err := parseString(value); err == nil {
+				filters[key] = text
+			}
+		}
+	}
+	return filters
+}
+
+// saveFilter records filter as the last one applied in repo. An empty
+// filter forgets the repo's entry.
+func saveFilter(repo, filter string) error {
+	path := statePath()
+	if path == "" {
+		return fmt.Errorf("no state directory")
+	}
+	filters := loadFilters()
+	if filter != "" {
+		filters[repo] = filter
+	} else {
+		delete(filters, repo)
+	}
+
+	repos := make([]string, 0, len(filters))
+	for r := range filters {
+		repos = append(repos, r)
+	}
+	sort.Strings(repos)
+	out := "[filters]\n"
+	for _, r := range repos {
+		out += fmt.Sprintf("%s = %s\n", strconv.Quote(r), strconv.Quote(filters[r]))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(out), 0o644)
+}
+
+// parseString reads a quoted TOML string.
+func parseString(value string) (string, error) {
+	return strconv.Unquote(value)
+}
+
+// parseStringArray reads a single-line TOML array of strings, e.g.
+// ["a", "b"].
+func parseStringArray(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("expected an array")
+	}
+	inner := strings.TrimSpace(value[1 : len(value)-1])
+	var items []string
+	for inner != "" {
+		quoted, err := strconv.QuotedPrefix(inner)
+		if err != nil {
+			return nil, err
+		}
+		item, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		inner = strings.TrimSpace(inner[len(quoted):])
+		inner = strings.TrimSpace(strings.TrimPrefix(inner, ","))
+	}
+	return items, nil
+}
+
+// stringList is a flag.Value that collects every use of a repeatable flag.
+// Values given on the command line replace those from the config file.
+type stringList struct {
+	values *[]string
+	set    bool
+}
+
+func (l *stringList) String() string {
+	if l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	if !l.set {
+		*l.values = nil
+		l.set = true
+	}
+	*l.values = append(*l.values, value)
+	return nil
+}
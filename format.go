@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// formatFields are the placeholders a --format template can use.
+var formatFields = []string{"name", "date", "author", "email", "ahead", "behind", "track", "subject"}
+
+// formatPart is a piece of a --format template: literal text, or the
+// field a placeholder stands for.
+type formatPart struct {
+	text  string
+	field string
+}
+
+// rowFormat is a parsed --format template. A nil rowFormat means the
+// default columns.
+type rowFormat []formatPart
+
+// parseRowFormat parses a template such as "{name}  {date}  {subject}".
+func parseRowFormat(s string) (rowFormat, error) {
+	var f rowFormat
+	for s != "" {
+		open := strings.IndexByte(s, '{')
+		if open < 0 {
+			f = append(f, formatPart{text: s})
+			break
+		}
+		if open > 0 {
+			f = append(f, formatPart{text: s[:open]})
+		}
+		end := strings.IndexByte(s[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in format %q", s[open:])
+		}
+		field := s[open+1 : open+end]
+		if !slices.Contains(formatFields, field) {
+			return nil, fmt.Errorf("unknown placeholder {%s} in format (want one of {%s})", field, strings.Join(formatFields, "}, {"))
+		}
+		f = append(f, formatPart{field: field})
+		s = s[open+end+1:]
+	}
+	return f, nil
+}
+
+// formatValue returns the text a placeholder expands to for b.
+func formatValue(b branchInfo, field string) string {
+	switch field {
+	case "name":
+		return b.name
+	case "date":
+		return b.lastCommit
+	case "author":
+		return b.author
+	case "email":
+		return b.email
+	case "ahead":
+		return strconv.Itoa(b.ahead)
+	case "behind":
+		return strconv.Itoa(b.behind)
+	case "track":
+		return trackLabel(b)
+	case "subject":
+		return b.subject
+	}
+	return ""
+}
+
+// widths returns the widest value of each placeholder among branches, so
+// the rows of a template line up like the default columns. The name is
+// narrowed to fit width, if known, after everything but the subject, which
+// makes do with whatever room is left.
+func (f rowFormat) widths(branches []branchInfo, indent, width int) map[string]int {
+	widths := map[string]int{}
+	for _, b := range branches {
+		if b.header {
+			continue
+		}
+		for _, p := range f {
+			if p.field != "" {
+				widths[p.field] = max(widths[p.field], lipgloss.Width(formatValue(b, p.field)))
+			}
+		}
+	}
+	widths["name"] += indent
+	if width > 0 {
+		rest := 0
+		for _, p := range f {
+			if p.field == "" {
+				rest += lipgloss.Width(p.text)
+			} else if p.field != "name" && p.field != "subject" {
+				rest += widths[p.field]
+			}
+		}
+		widths["name"] = max(1, min(widths["name"], width-rest))
+	}
+	return widths
+}
+
+// formatRow renders b with the --format template in width columns, if
+// known. The name gets the row's style and filter highlighting, the date
+// its age color.
+func (m model) formatRow(b branchInfo, name string, widths map[string]int, width int, style lipgloss.Style) string {
+	t := m.theme
+	var row strings.Builder
+	for _, p := range m.format {
+		if p.field == "" {
+			row.WriteString(p.text)
+			continue
+		}
+		value := formatValue(b, p.field)
+		pad := strings.Repeat(" ", max(0, widths[p.field]-lipgloss.Width(value)))
+		switch p.field {
+		case "name":
+			row.WriteString(m.renderName(b, name, widths["name"], style))
+			continue
+		case "date":
+			value = m.ageStyle(b).Render(value)
+		case "subject":
+			if room := width - lipgloss.Width(row.String()); width > 0 && room < 5 {
+				value = ""
+			} else if width > 0 {
+				value = truncate(value, room)
+			}
+			value = t.dim.Render(value)
+		case "track":
+			value = t.dim.Render(value)
+		}
+		row.WriteString(value + pad)
+	}
+	return row.String()
+}
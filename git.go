@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gitRunner runs git commands. Everything that talks to git goes through
+// it, so tests can swap in a fake instead of needing a real repository.
+type gitRunner interface {
+	// Output runs git and returns its standard output. A failing command's
+	// error carries git's own message when it printed one.
+	Output(args ...string) ([]byte, error)
+}
+
+// git is the runner used for every git command.
+var git gitRunner = execGit{}
+
+// gitPath is the git executable to run, set with --git-path or
+// GIT_RECENT_GIT. A bare name is looked up in PATH.
+var gitPath = "git"
+
+// workDir is the directory git commands run in, set with -C. Empty means
+// the current directory.
+var workDir string
+
+// gitTimeout is how long a git command may run before it is killed, set
+// with --timeout. Zero means no limit.
+var gitTimeout = 30 * time.Second
+
+// findGit checks that gitPath can be run, so a missing git is reported
+// plainly before the picker starts rather than as a failed command.
+func findGit() error {
+	if _, err := exec.LookPath(gitPath); err != nil {
+		if gitPath == "git" {
+			return errors.New("git executable not found in PATH")
+		}
+		return fmt.Errorf("git executable %s not found or not executable", gitPath)
+	}
+	return nil
+}
+
+// execGit runs the git executable in workDir.
+type execGit struct{}
+
+func (execGit) Output(args ...string) ([]byte, error) {
+	ctx, cancel := gitContext(args)
+	defer cancel()
+	cmd := gitCommand(ctx, args...)
+	output, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = timeoutError(args)
+		logCommand(cmd, err, "")
+		return output, err
+	}
+	var stderr string
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		stderr = strings.TrimSpace(string(exitErr.Stderr))
+	}
+	logCommand(cmd, err, stderr)
+	if stderr != "" {
+		return output, errors.New(stderr)
+	}
+	return output, err
+}
+
+// debugLogPath returns where --debug writes its log.
+func debugLogPath() string {
+	return filepath.Join(os.TempDir(), "git-recent-debug.log")
+}
+
+// logCommand records a finished git command in the debug log, which
+// discards everything unless --debug is given.
+func logCommand(cmd *exec.Cmd, err error, stderr string) {
+	code := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		log.Printf("%s: %v", strings.Join(cmd.Args, " "), err)
+		return
+	}
+	log.Printf("%s: exit %d", strings.Join(cmd.Args, " "), code)
+	if stderr != "" {
+		log.Printf("  stderr: %s", stderr)
+	}
+}
+
+// untimedCommands change the repository, and killing them partway could
+// leave a half-updated work tree or a stale index.lock behind, so they
+// run without gitTimeout.
+var untimedCommands = map[string]bool{
+	"checkout":   true,
+	"stash":      true,
+	"worktree":   true,
+	"branch":     true,
+	"update-ref": true,
+}
+
+// gitContext returns the context the git command args runs under, which
+// expires after gitTimeout unless it is one of untimedCommands.
+func gitContext(args []string) (context.Context, context.CancelFunc) {
+	if gitTimeout <= 0 || untimedCommands[args[0]] {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), gitTimeout)
+}
+
+// errGitTimeout is wrapped by the errors of git commands that were killed
+// for running longer than gitTimeout.
+var errGitTimeout = errors.New("timed out")
+
+func timeoutError(args []string) error {
+	return fmt.Errorf("git %s %w after %s (see --timeout)", args[0], errGitTimeout, gitTimeout)
+}
+
+// gitCommand builds a git command that runs in workDir until ctx is done.
+func gitCommand(ctx context.Context, args ...string) *exec.Cmd {
+	if workDir != "" {
+		args = append([]string{"-C", workDir}, args...)
+	}
+	cmd := exec.CommandContext(ctx, gitPath, args...)
+	// Don't wait on children such as ssh that keep git's output open
+	// after git itself has been killed
+	cmd.WaitDelay = time.Second
+	return cmd
+}
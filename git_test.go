@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGit answers git commands with canned output instead of running git.
+// Commands are matched by the longest key their command line starts with,
+// and every command line run is recorded.
+type fakeGit struct {
+	output map[string]string // stdout of matching commands
+	fail   map[string]string // matching commands fail with this error
+	ran    []string
+	onRun  func(line string) // called after each command, to change state
+}
+
+func (f *fakeGit) Output(args ...string) ([]byte, error) {
+	line := strings.Join(args, " ")
+	f.ran = append(f.ran, line)
+	output := f.output[longestPrefix(line, f.output)]
+	if f.onRun != nil {
+		defer f.onRun(line)
+	}
+	if key := longestPrefix(line, f.fail); key != "" {
+		return []byte(output), errors.New(f.fail[key])
+	}
+	return []byte(output), nil
+}
+
+func longestPrefix(line string, m map[string]string) string {
+	best := ""
+	for key := range m {
+		if strings.HasPrefix(line, key) && len(key) > len(best) {
+			best = key
+		}
+	}
+	return best
+}
+
+// useGit makes f the git runner until the test ends.
+func useGit(t *testing.T, f *fakeGit) {
+	t.Helper()
+	saved := git
+	git = f
+	t.Cleanup(func() { git = saved })
+}
+
+// refLine builds a line of getRecentBranches' for-each-ref output.
+func refLine(fields ...string) string {
+	return strings.Join(fields, "\t")
+}
+
+func TestGetRecentBranches(t *testing.T) {
+	f := &fakeGit{output: map[string]string{
+		"for-each-ref": strings.Join([]string{
+			refLine("refs/heads/main", "main", "2 days ago", "[ahead 2, behind 1]", "Ada", "<ada@example.com>", "1700000000", "Fix the\tparser"),
+			refLine("refs/heads/old", "old", "3 weeks ago", "[gone]", "Bob", "<bob@example.com>", "1690000000", "WIP"),
+			refLine("refs/tags/v1.0", "v1.0", "1 month ago", "", "Ada", "<ada@example.com>", "1680000000", "Release 1.0"),
+		}, "\n"),
+	}}
+	useGit(t, f)
+
+	got, err := getRecentBranches(branchQuery{sort: "-date", dates: "relative", tags: true, limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []branchInfo{
+		{name: "main", lastCommit: "2 days ago", ahead: 2, behind: 1, author: "Ada", email: "ada@example.com", committed: 1700000000, subject: "Fix the\tparser"},
+		{name: "old", lastCommit: "3 weeks ago", gone: true, author: "Bob", email: "bob@example.com", committed: 1690000000, subject: "WIP"},
+		{name: "v1.0", tag: true, lastCommit: "1 month ago", author: "Ada", email: "ada@example.com", committed: 1680000000, subject: "Release 1.0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getRecentBranches() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	args := f.ran[0]
+	for _, part := range []string{"--sort=-creatordate", "--count=10", "refs/heads/", "refs/tags/"} {
+		if !strings.Contains(args, part) {
+			t.Errorf("for-each-ref args %q are missing %q", args, part)
+		}
+	}
+}
+
+func TestGetRecentBranchesRemotes(t *testing.T) {
+	useGit(t, &fakeGit{output: map[string]string{
+		"remote": "origin\nupstream/mirror\n",
+		"for-each-ref": strings.Join([]string{
+			refLine("refs/remotes/origin/HEAD", "origin/HEAD", "1 day ago"),
+			refLine("refs/remotes/upstream/mirror/feature/x", "upstream/mirror/feature/x", "1 day ago"),
+			refLine("refs/remotes/origin/main", "origin/main", "2 days ago"),
+		}, "\n"),
+	}})
+
+	got, err := getRecentBranches(branchQuery{remote: true, sort: "-date", dates: "relative"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names, remotes []string
+	for _, b := range got {
+		if !b.remote {
+			t.Errorf("%s isn't marked remote", b.name)
+		}
+		names = append(names, b.name)
+		remotes = append(remotes, b.remoteName)
+	}
+	if want := []string{"upstream/mirror/feature/x", "origin/main"}; !slices.Equal(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+	if want := []string{"upstream/mirror", "origin"}; !slices.Equal(remotes, want) {
+		t.Errorf("remotes = %q, want %q", remotes, want)
+	}
+}
+
+func TestGetRecentBranchesError(t *testing.T) {
+	useGit(t, &fakeGit{fail: map[string]string{"for-each-ref": "fatal: not a git repository"}})
+
+	if _, err := getRecentBranches(branchQuery{}); err == nil || err.Error() != "fatal: not a git repository" {
+		t.Errorf("err = %v, want git's message", err)
+	}
+}
+
+func TestCheckoutCmd(t *testing.T) {
+	tests := []struct {
+		name    string
+		branch  branchInfo
+		stash   bool
+		fail    map[string]string
+		ran     []string
+		log     []string
+		err     string
+		popFail bool
+	}{
+		{
+			name:   "local",
+			branch: branchInfo{name: "feature/x"},
+			ran:    []string{"checkout feature/x"},
+			log:    []string{"Checked out: feature/x"},
+		},
+		{
+			name:   "new tracking branch",
+			branch: branchInfo{name: "upstream/release/1.0", remote: true},
+			fail:   map[string]string{"show-ref": ""},
+			ran:    []string{"remote", "show-ref --verify --quiet refs/heads/release/1.0", "checkout -b release/1.0 --track upstream/release/1.0"},
+			log:    []string{"Created release/1.0 to track upstream/release/1.0"},
+		},
+		{
+			name:   "existing tracking branch",
+			branch: branchInfo{name: "origin/main", remote: true},
+			ran:    []string{"remote", "show-ref --verify --quiet refs/heads/main", "checkout main"},
+			log:    []string{"Checked out existing local branch main for origin/main"},
+		},
+		{
+			name:   "tag",
+			branch: branchInfo{name: "v1.0", tag: true},
+			ran:    []string{"checkout --detach v1.0"},
+			log:    []string{"Checked out v1.0 as a detached HEAD"},
+		},
+		{
+			name:   "failed checkout reapplies the stash",
+			branch: branchInfo{name: "feature/x"},
+			stash:  true,
+			fail:   map[string]string{"checkout": "error: untracked files would be overwritten"},
+			ran:    []string{"rev-parse -q --verify refs/stash", "stash", "rev-parse -q --verify refs/stash", "checkout feature/x", "stash pop"},
+			err:    "error: untracked files would be overwritten",
+		},
+		{
+			name:    "stash pop conflict",
+			branch:  branchInfo{name: "feature/x"},
+			stash:   true,
+			fail:    map[string]string{"stash pop": "exit status 1"},
+			ran:     []string{"rev-parse -q --verify refs/stash", "stash", "rev-parse -q --verify refs/stash", "checkout feature/x", "stash pop"},
+			log:     []string{"Stashed local changes", "Checked out: feature/x"},
+			popFail: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeGit{output: map[string]string{"remote": "origin\nupstream\n"}, fail: tt.fail}
+			// A new stash entry means there were changes to stash
+			f.onRun = func(line string) {
+				if line == "stash" {
+					f.output["rev-parse -q --verify refs/stash"] = "4b825dc\n"
+				}
+			}
+			useGit(t, f)
+
+			msg := checkoutCmd(tt.branch, false, tt.stash, true)().(checkoutResultMsg)
+			if !slices.Equal(f.ran, tt.ran) {
+				t.Errorf("ran %q, want %q", f.ran, tt.ran)
+			}
+			if tt.err == "" && msg.err != nil || tt.err != "" && (msg.err == nil || msg.err.Error() != tt.err) {
+				t.Errorf("err = %v, want %q", msg.err, tt.err)
+			}
+			if tt.err == "" && !slices.Equal(msg.log, tt.log) {
+				t.Errorf("log = %q, want %q", msg.log, tt.log)
+			}
+			if (msg.popErr != nil) != tt.popFail {
+				t.Errorf("popErr = %v, want failure %v", msg.popErr, tt.popFail)
+			}
+		})
+	}
+}
+
+func TestPopStashReportsConflicts(t *testing.T) {
+	useGit(t, &fakeGit{
+		output: map[string]string{"stash pop": "Auto-merging f\nCONFLICT (content): Merge conflict in f\n"},
+		fail:   map[string]string{"stash pop": "exit status 1"},
+	})
+
+	err := popStash()
+	if err == nil || !strings.Contains(err.Error(), "CONFLICT (content): Merge conflict in f") {
+		t.Errorf("popStash() = %v, want git's conflict report", err)
+	}
+}
+
+func TestFindGit(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+
+	if err := findGit(); err == nil || err.Error() != "git executable not found in PATH" {
+		t.Errorf("findGit() without git in PATH = %v", err)
+	}
+
+	stub := filepath.Join(dir, "git")
+	if err := os.WriteFile(stub, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := findGit(); err != nil {
+		t.Errorf("findGit() with git in PATH = %v", err)
+	}
+
+	saved := gitPath
+	gitPath = filepath.Join(dir, "missing")
+	t.Cleanup(func() { gitPath = saved })
+	if err := findGit(); err == nil || !strings.Contains(err.Error(), "not found or not executable") {
+		t.Errorf("findGit() with a missing --git-path = %v", err)
+	}
+}
+
+func TestTimeoutSparesCommandsThatChangeTheRepo(t *testing.T) {
+	stub := filepath.Join(t.TempDir(), "git")
+	if err := os.WriteFile(stub, []byte("#!/bin/sh\nsleep 0.5\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	savedPath, savedTimeout := gitPath, gitTimeout
+	gitPath, gitTimeout = stub, 100*time.Millisecond
+	t.Cleanup(func() { gitPath, gitTimeout = savedPath, savedTimeout })
+	// The debug log, which main otherwise discards
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	if _, err := (execGit{}).Output("fetch", "--all"); !errors.Is(err, errGitTimeout) {
+		t.Errorf("fetch err = %v, want a timeout", err)
+	}
+	if _, err := (execGit{}).Output("checkout", "main"); err != nil {
+		t.Errorf("checkout err = %v, want it to run to the end", err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// grouped reports whether the list is arranged under group headers. A
+// filtered list is ranked by match instead, so it isn't grouped.
+func (m model) grouped() bool {
+	return m.group && m.filterText == ""
+}
+
+// groupOf returns the group b belongs to in --group mode: the first path
+// segment of its name, with its slash, e.g. "feature/". Remote branches
+// are grouped without their remote. Names without a slash belong to none.
+func (m model) groupOf(b branchInfo) string {
+	name := b.name
+	if branch, ok := b.remoteBranch(); ok {
+		name = branch
+	}
+	prefix, _, ok := strings.Cut(name, "/")
+	if !ok {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// groupRows arranges branches under a header row for each group, placed
+// where the group's first branch was so the most active groups stay on
+// top. Branches of collapsed groups are left out, and branches that
+// belong to no group keep their place.
+func (m model) groupRows(branches []branchInfo) []branchInfo {
+	var order []branchInfo
+	members := map[string][]branchInfo{}
+	for _, b := range branches {
+		group := m.groupOf(b)
+		if group == "" {
+			order = append(order, b)
+			continue
+		}
+		if _, ok := members[group]; !ok {
+			order = append(order, branchInfo{name: group, header: true})
+		}
+		members[group] = append(members[group], b)
+	}
+
+	rows := make([]branchInfo, 0, len(order)+len(branches))
+	for _, row := range order {
+		if !row.header {
+			rows = append(rows, row)
+			continue
+		}
+		row.size = len(members[row.name])
+		rows = append(rows, row)
+		if !m.collapsed[row.name] {
+			rows = append(rows, members[row.name]...)
+		}
+	}
+	return rows
+}
+
+// onHeader reports whether the cursor is on a group header.
+func (m model) onHeader() bool {
+	return len(m.branches) > 0 && m.branches[m.cursor].header
+}
+
+// branchCount returns how many branches are listed, not counting group
+// headers but counting the branches of collapsed groups.
+func (m model) branchCount() int {
+	return m.countBranches(m.branches)
+}
+
+// position returns where the cursor is among the branches branchCount
+// counts. On a group header it is the position of the group's first
+// branch.
+func (m model) position() int {
+	return min(m.countBranches(m.branches[:m.cursor])+1, m.branchCount())
+}
+
+// countBranches counts the branches in rows the way branchCount does.
+func (m model) countBranches(rows []branchInfo) int {
+	n := 0
+	for _, b := range rows {
+		switch {
+		case !b.header:
+			n++
+		case m.collapsed[b.name]:
+			n += b.size
+		}
+	}
+	return n
+}
+
+// toggleGroup collapses the group of the highlighted branch, or expands
+// or collapses the highlighted group header, leaving the cursor on the
+// header.
+func (m *model) toggleGroup() {
+	if !m.grouped() || len(m.branches) == 0 {
+		return
+	}
+	b := m.branches[m.cursor]
+	group := b.name
+	if !b.header {
+		group = m.groupOf(b)
+	}
+	if group == "" {
+		return
+	}
+	m.collapsed[group] = !m.collapsed[group]
+	m.applyFilter()
+	m.highlight(group)
+}
+
+// headerView renders a group header row.
+func (m model) headerView(b branchInfo, selected bool) string {
+	t := m.theme
+	arrow := "▾"
+	if m.collapsed[b.name] {
+		arrow = "▸"
+	}
+	label := fmt.Sprintf("%s %s", arrow, b.name)
+	count := t.dim.Render(fmt.Sprintf(" (%d)", b.size))
+	if selected {
+		return t.cursor.Render(t.cursorMark) + " " + t.selected.Render(label) + count
+	}
+	return "  " + label + count
+}
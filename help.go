@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// helpSection is a titled group of keybindings shown in the help overlay.
+type helpSection struct {
+	title string
+	keys  []helpKey
+}
+
+// helpKey describes one entry of the help overlay. Entries for remappable
+// actions name the action, and show whatever keys it is bound to.
+type helpKey struct {
+	keys   string // fixed keys, when action is empty
+	action string // name in keyActions
+	desc   string
+}
+
+var helpSections = []helpSection{
+	{"Navigation", []helpKey{
+		{action: "up", desc: "move up"},
+		{action: "down", desc: "move down"},
+		{action: "top", desc: "jump to the first branch"},
+		{action: "bottom", desc: "jump to the last branch"},
+		{action: "page_up", desc: "move up a page"},
+		{action: "page_down", desc: "move down a page"},
+		{keys: "5j, 3G", desc: "repeat a move, or jump to a row, with a count"},
+		{keys: "click", desc: "move the cursor, double click to check out"},
+	}},
+	{"Branches", []helpKey{
+		{action: "select", desc: "check out the selected branch"},
+		{action: "detach", desc: "check out a remote branch as a detached HEAD"},
+		{action: "back", desc: "check out the previous branch, like git checkout -"},
+		{action: "worktree", desc: "open the branch in a new worktree instead"},
+		{action: "new", desc: "create a new branch from HEAD"},
+		{action: "rename", desc: "rename the selected branch"},
+		{action: "delete", desc: "delete the selected branch, or every marked one"},
+		{action: "undo", desc: "restore the last deleted branch"},
+		{action: "mark", desc: "mark or unmark the branch for deleting"},
+		{action: "copy", desc: "copy the branch name"},
+		{action: "open", desc: "open a pull request in the browser"},
+		{action: "pin", desc: "pin or unpin the branch"},
+		{action: "info", desc: "show the branch's details"},
+	}},
+	{"View", []helpKey{
+		{action: "filter", desc: "filter branches"},
+		{keys: "esc", desc: "clear the filter or count, or quit"},
+		{action: "sort", desc: "cycle the sort order"},
+		{action: "refresh", desc: "reload the branch list"},
+		{action: "fetch", desc: "fetch all remotes, then reload"},
+		{action: "preview", desc: "toggle the commit preview"},
+		{action: "subjects", desc: "toggle commit subjects"},
+		{action: "compact", desc: "toggle the compact layout"},
+		{action: "merged", desc: "show all, merged, unmerged or gone branches"},
+		{action: "fold", desc: "collapse or expand a group (with --group)"},
+		{action: "help", desc: "toggle this help"},
+		{action: "quit", desc: "quit"},
+		{keys: "ctrl+c", desc: "quit from anywhere, once a checkout has finished"},
+	}},
+	{"While filtering", []helpKey{
+		{keys: "enter", desc: "keep the filtered list"},
+		{keys: "esc", desc: "cancel the filter"},
+		{keys: "←/→", desc: "move the caret"},
+		{keys: "home/end", desc: "jump to the start or end of the filter"},
+		{keys: "delete", desc: "delete the character under the caret"},
+		{keys: "ctrl+s", desc: "toggle case sensitivity"},
+		{keys: "ctrl+r", desc: "toggle regular expressions"},
+		{keys: "ctrl+t", desc: "toggle matching branch names or authors"},
+	}},
+}
+
+// label returns the keys shown for h with the current bindings.
+func (h helpKey) label(keys keyMap) string {
+	if h.action == "" {
+		return h.keys
+	}
+	return keys.lookup(h.action).label()
+}
+
+// helpView renders the full keybinding reference shown by ?.
+func (m model) helpView() string {
+	t := m.theme
+
+	keyWidth := 0
+	for _, section := range helpSections {
+		for _, k := range section.keys {
+			keyWidth = max(keyWidth, lipgloss.Width(k.label(m.keys)))
+		}
+	}
+	keyStyle := t.selected.Copy().Width(keyWidth + 2)
+
+	var blocks []string
+	for _, section := range helpSections {
+		lines := []string{t.dim.Render(section.title)}
+		for _, k := range section.keys {
+			lines = append(lines, keyStyle.Render(k.label(m.keys))+k.desc)
+		}
+		blocks = append(blocks, strings.Join(lines, "\n"))
+	}
+
+	box := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		Padding(0, 1).
+		Render(strings.Join(blocks, "\n\n"))
+	return box + "\n" + t.dim.Render(fmt.Sprintf("(%s or esc to close)", m.keys.help.hint())) + "\n"
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// branchDetails is everything the i overlay shows about one branch.
+type branchDetails struct {
+	branch   branchInfo
+	hash     string
+	author   string
+	date     string
+	subject  string
+	upstream string // empty for remote branches and untracked local ones
+}
+
+// loadDetails asks git for the parts of b's details that the branch list
+// doesn't already carry.
+func loadDetails(b branchInfo) (*branchDetails, error) {
+	output, err := git.Output("log", "-1", "--format=%H%x09%an <%ae>%x09%ci (%cr)%x09%s", b.name, "--")
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(strings.TrimSpace(string(output)), "\t", 4)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("no commits on %s", b.name)
+	}
+	d := &branchDetails{branch: b, hash: fields[0], author: fields[1], date: fields[2], subject: fields[3]}
+	if !b.remote {
+		if output, err := git.Output("for-each-ref", "--format=%(upstream:short)", "refs/heads/"+b.name); err == nil {
+			d.upstream = strings.TrimSpace(string(output))
+		}
+	}
+	return d, nil
+}
+
+// infoView renders the details of the branch opened with i.
+func (m model) infoView() string {
+	t := m.theme
+	d := m.info
+
+	upstream := "none"
+	if d.branch.remote {
+		upstream = "remote branch"
+	} else if d.upstream != "" {
+		upstream = d.upstream
+		if track := trackLabel(d.branch); track != "" {
+			upstream += " (" + track + ")"
+		} else {
+			upstream += " (up to date)"
+		}
+	}
+
+	merged := "unknown (no base branch found; set one with --base)"
+	if m.query.base != "" {
+		if d.branch.name == m.query.base {
+			merged = "this is the default branch"
+		} else if d.branch.merged {
+			merged = "yes, into " + m.query.base
+		} else {
+			merged = "no, not into " + m.query.base
+		}
+	}
+
+	rows := [][2]string{
+		{"Branch", d.branch.name},
+		{"Commit", d.hash},
+		{"Author", d.author},
+		{"Date", d.date},
+		{"Subject", d.subject},
+		{"Upstream", upstream},
+		{"Merged", merged},
+	}
+	labelStyle := t.dim.Copy().Width(10)
+	var lines []string
+	for _, r := range rows {
+		value := r[1]
+		if m.width > 0 {
+			value = truncate(value, m.width-16)
+		}
+		lines = append(lines, labelStyle.Render(r[0])+value)
+	}
+
+	box := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		Padding(0, 1).
+		Render(strings.Join(lines, "\n"))
+	return box + "\n" + t.dim.Render(fmt.Sprintf("(%s or esc to close)", m.keys.info.hint())) + "\n"
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// binding is the keys that trigger one action, as tea.KeyMsg.String()
+// spells them. The first is the one shown in hints.
+type binding []string
+
+// has reports whether key triggers the action.
+func (b binding) has(key string) bool {
+	return slices.Contains(b, key)
+}
+
+// hint returns the key to mention in the short help line.
+func (b binding) hint() string {
+	if len(b) == 0 {
+		return "(unbound)"
+	}
+	return keyLabel(b[0])
+}
+
+// label lists every key, for the help overlay.
+func (b binding) label() string {
+	if len(b) == 0 {
+		return "(unbound)"
+	}
+	labels := make([]string, len(b))
+	for i, key := range b {
+		labels[i] = keyLabel(key)
+	}
+	return strings.Join(labels, "/")
+}
+
+// keyLabel spells a key the way the help shows it.
+func keyLabel(key string) string {
+	switch key {
+	case " ":
+		return "space"
+	case "up":
+		return "↑"
+	case "down":
+		return "↓"
+	}
+	return key
+}
+
+// keyMap holds the remappable keys of the branch list. Keys in prompts,
+// confirmations and filter mode, esc and ctrl+c are fixed.
+type keyMap struct {
+	quit     binding
+	filter   binding
+	up       binding
+	down     binding
+	top      binding
+	bottom   binding
+	pageUp   binding
+	pageDown binding
+	choose   binding
+	detach   binding
+	back     binding
+	fold     binding
+	mark     binding
+	delete   binding
+	undo     binding
+	preview  binding
+	subjects binding
+	compact  binding
+	merged   binding
+	help     binding
+	info     binding
+	create   binding
+	rename   binding
+	worktree binding
+	open     binding
+	copy     binding
+	pin      binding
+	sort     binding
+	refresh  binding
+	fetch    binding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		quit:     binding{"q"},
+		filter:   binding{"/"},
+		up:       binding{"k", "up"},
+		down:     binding{"j", "down"},
+		top:      binding{"g", "home"},
+		bottom:   binding{"G", "end"},
+		pageUp:   binding{"ctrl+u", "pgup"},
+		pageDown: binding{"ctrl+d", "pgdown"},
+		choose:   binding{"enter"},
+		detach:   binding{"alt+enter"},
+		back:     binding{"-"},
+		fold:     binding{"tab"},
+		mark:     binding{" "},
+		delete:   binding{"d"},
+		undo:     binding{"u"},
+		preview:  binding{"p"},
+		subjects: binding{"c"},
+		compact:  binding{"z"},
+		merged:   binding{"m"},
+		help:     binding{"?"},
+		info:     binding{"i"},
+		create:   binding{"n"},
+		rename:   binding{"r"},
+		worktree: binding{"w"},
+		open:     binding{"o"},
+		copy:     binding{"y"},
+		pin:      binding{"f"},
+		sort:     binding{"s"},
+		refresh:  binding{"ctrl+r", "f5"},
+		fetch:    binding{"F"},
+	}
+}
+
+// keyActions are the action names used in the [keys] section of the
+// config file.
+var keyActions = []string{
+	"quit", "filter", "up", "down", "top", "bottom", "page_up", "page_down",
+	"select", "detach", "back", "fold", "mark", "delete", "undo", "preview", "subjects", "compact", "merged",
+	"help", "info", "new", "rename", "worktree", "open", "copy", "pin", "sort", "refresh", "fetch",
+}
+
+// lookup returns the binding for a config action name, or nil for an
+// unknown one.
+func (k *keyMap) lookup(action string) *binding {
+	switch action {
+	case "quit":
+		return &k.quit
+	case "filter":
+		return &k.filter
+	case "up":
+		return &k.up
+	case "down":
+		return &k.down
+	case "top":
+		return &k.top
+	case "bottom":
+		return &k.bottom
+	case "page_up":
+		return &k.pageUp
+	case "page_down":
+		return &k.pageDown
+	case "select":
+		return &k.choose
+	case "detach":
+		return &k.detach
+	case "back":
+		return &k.back
+	case "fold":
+		return &k.fold
+	case "mark":
+		return &k.mark
+	case "delete":
+		return &k.delete
+	case "undo":
+		return &k.undo
+	case "preview":
+		return &k.preview
+	case "subjects":
+		return &k.subjects
+	case "compact":
+		return &k.compact
+	case "merged":
+		return &k.merged
+	case "help":
+		return &k.help
+	case "info":
+		return &k.info
+	case "new":
+		return &k.create
+	case "rename":
+		return &k.rename
+	case "worktree":
+		return &k.worktree
+	case "open":
+		return &k.open
+	case "copy":
+		return &k.copy
+	case "pin":
+		return &k.pin
+	case "sort":
+		return &k.sort
+	case "refresh":
+		return &k.refresh
+	case "fetch":
+		return &k.fetch
+	}
+	return nil
+}
+
+// set binds keys to action, taking them away from any other action so
+// each key does one thing. Unknown actions are ignored, like unknown
+// config keys.
+func (k *keyMap) set(action string, keys []string) {
+	b := k.lookup(action)
+	if b == nil {
+		return
+	}
+	for i, key := range keys {
+		if key == "space" {
+			keys[i] = " "
+		}
+	}
+	for _, other := range keyActions {
+		o := k.lookup(other)
+		*o = slices.DeleteFunc(slices.Clone(*o), func(key string) bool {
+			return slices.Contains(keys, key)
+		})
+	}
+	*b = keys
+}
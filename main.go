@@ -1,260 +1,3175 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"os/exec"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"golang.org/x/term"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+type branchInfo struct {
+	name       string
+	lastCommit string // committer date, e.g. "2 days ago" or "2024-05-01"
+	committed  int64  // committer date as a Unix timestamp
+	ahead      int    // commits not yet on the upstream
+	behind     int    // upstream commits not yet on this branch
+	remote     bool   // a remote-tracking branch rather than a local one
+	remoteName string // the remote of a remote-tracking branch, if configured
+	tag        bool   // a tag, listed with --tags
+	subject    string // subject line of the tip commit
+	author     string // author of the tip commit
+	foldName   string // name lowercased once, for case-insensitive filtering
+	foldAuthor string // author lowercased once
+	email      string // author email of the tip commit
+	merged     bool   // already merged into the base branch
+	gone       bool   // its upstream was deleted
+	header     bool   // a group header row in --group mode, not a branch
+	size       int    // number of branches under a group header
+}
+
+// promptKind identifies which text prompt, if any, is being typed into.
+type promptKind int
+
+const (
+	promptNone      promptKind = iota
+	promptNewBranch            // name for a branch to create
+	promptRename               // new name for the highlighted branch
+)
+
+// confirmKind identifies which yes/no question, if any, is being asked.
+type confirmKind int
+
+const (
+	confirmNone         confirmKind = iota
+	confirmDirty                    // checkout over uncommitted changes
+	confirmDelete                   // delete the highlighted branch
+	confirmForceDelete              // force delete an unmerged branch
+	confirmPushDelete               // delete a branch on the remote itself
+	confirmDeleteMarked             // delete every marked branch
+	confirmDetached                 // leave commits on a detached HEAD behind
+)
+
+// statusFilter narrows the list by branch status, ahead of any text filter.
+type statusFilter int
+
+const (
+	showAll      statusFilter = iota
+	showMerged                // only branches merged into the base
+	showUnmerged              // only branches not merged into the base
+	showGone                  // only branches whose upstream was deleted
+)
+
+func (f statusFilter) String() string {
+	switch f {
+	case showMerged:
+		return "merged"
+	case showUnmerged:
+		return "unmerged"
+	case showGone:
+		return "gone"
+	}
+	return "all"
+}
+
 type model struct {
-	branches        []string
-	allBranches     []string // original unfiltered list
+	branches        []branchInfo
+	allBranches     []branchInfo // original unfiltered list
+	keys            keyMap
+	group           bool            // branches are listed under a header per prefix
+	collapsed       map[string]bool // groups whose branches are hidden
 	cursor          int
 	offset          int
-	remote          bool
+	count           int         // number typed before a movement key, 0 if none
+	pageSize        int         // number of branches visible at once
+	fixedHeight     bool        // pageSize was set explicitly, ignore terminal size
+	width           int         // terminal width, 0 until the first WindowSizeMsg
+	height          int         // terminal height, 0 until the first WindowSizeMsg
+	query           branchQuery // how branches were listed, for reloading
+	selectOnly      bool        // the selection is printed rather than checked out
+	dryRun          bool        // the checkout is described rather than run
+	worktree        bool        // selecting adds a worktree rather than checking out
+	worktreeDir     string      // where a worktree for the chosen branch goes
+	current         string      // currently checked out branch, empty if detached
+	strandedHead    bool        // HEAD is detached at commits no ref contains
+	unpushed        int         // commits on the current branch not yet on its upstream
+	theme           theme
+	agingAfter      time.Duration     // age at which a branch's date turns from fresh to aging
+	staleAfter      time.Duration     // age at which it turns stale
+	repoRoot        string            // top level of the work tree, for per-repo settings
+	pins            map[string]bool   // pinned branches, listed first
+	marked          map[string]bool   // branches picked with space for a batch delete
+	worktrees       map[string]string // branches checked out in other worktrees
+	remotes         []string          // configured remotes, for splitting remote refs
 	selected        bool
+	chosen          branchInfo // the branch being checked out or printed
+	stash           bool       // stash local changes before checking out
+	detach          bool       // check out the selection as a detached HEAD
+	checkingOut     bool       // the checkout is running in the background
+	checkoutLog     []string   // what the checkout did, printed once the picker exits
+	popErr          error      // the checkout worked but the stash didn't reapply
+	stashPop        bool       // reapply stashed changes after checking out
+	confirm         confirmKind
+	deleting        branchInfo      // the branch a delete confirmation is about
+	deleted         []deletedBranch // deleted branches, most recent last, for undo
+	prompt          promptKind
+	promptText      string
+	promptCaret     int // rune offset of the caret in promptText
+	promptErr       string
+	created         string    // branch created and checked out from the picker, or to be with --dry-run
+	status          string    // one-off message shown above the help line
+	deleteErr       string    // why the last delete was refused
+	lastClick       time.Time // for detecting double clicks
+	lastClickRow    int
+	loading         bool // branches are being listed in the background
+	loaded          bool // the first listing has completed
+	fetching        bool // a git fetch is running in the background
+	refreshing      bool // the user asked for the running reload
+	spinner         spinner.Model
+	showPreview     bool
+	showSubjects    bool                // last commit subject shown on each row
+	compact         bool                // only names and dates, without the header and help
+	format          rowFormat           // what each row shows, from --format
+	highlightRow    bool                // the highlighted row gets a background
+	showHelp        bool                // the keybinding overlay replaces the list
+	info            *branchDetails      // shown instead of the list when set
+	previews        map[string][]string // recent commit subjects, cached per branch
 	err             error
 	filterMode      bool
 	filterText      string
-	filteredApplied bool // tracks if we're showing a filtered list
+	filterCaret     int  // rune offset of the caret in filterText
+	caseSensitive   bool // filter matches case exactly
+	regexMode       bool // filter text is a regular expression
+	filterAuthor    bool // filter matches the tip commit's author, not the name
+	filterErr       string
+	matched         map[string][]int // rune positions in each name that match the filter
+	filteredApplied bool             // tracks if we're showing a filtered list
+	show            statusFilter
+	rememberFilter  bool // the applied filter is saved for the next run
+	liveFilter      bool // typing filters straight away, without pressing /
 }
 
-func getRecentBranches(remote bool) ([]string, error) {
-	var cmd *exec.Cmd
-	if remote {
-		cmd = exec.Command("git", "for-each-ref", "--sort=-committerdate", "refs/remotes/", "--format=%(refname:short)")
-	} else {
-		cmd = exec.Command("git", "for-each-ref", "--sort=-committerdate", "refs/heads/", "--format=%(refname:short)")
+// branchQuery describes which branches getRecentBranches lists and in what
+// order.
+type branchQuery struct {
+	remote     bool     // list remote-tracking branches instead of local ones
+	all        bool     // list local and remote-tracking branches together
+	remoteName string   // only list remote-tracking branches of this remote
+	limit      int      // maximum number of branches, 0 for no limit
+	sort       string   // one of sortOrders
+	exclude    []string // glob patterns of branches to hide
+	email      string   // only list branches whose tip was authored by this email
+	tags       bool     // list tags too
+	dates      string   // one of dateFormats
+	base       string   // branch to check merged status against, if any
+}
+
+// sortOrders are the accepted --sort values, in the order the s key cycles
+// through them. A leading "-" means descending, as in git.
+var sortOrders = []string{"-date", "date", "-authordate", "authordate", "name", "-name", "author", "checkout"}
+
+// sortKeys maps each sort order to for-each-ref --sort options. git applies
+// the last key first, so earlier keys break ties.
+var sortKeys = map[string][]string{
+	"-date": {"--sort=-committerdate"},
+	"date":  {"--sort=committerdate"},
+	// The author date survives rebases, which reset the committer date
+	"-authordate": {"--sort=-authordate"},
+	"authordate":  {"--sort=authordate"},
+	"name":        {"--sort=refname"},
+	"-name":       {"--sort=-refname"},
+	"author":      {"--sort=-committerdate", "--sort=authorname"},
+	// Reordered by the reflog afterwards, see sortByCheckout
+	"checkout": {"--sort=-committerdate"},
+}
+
+// reflogWindow is how many HEAD reflog entries sortByCheckout reads.
+const reflogWindow = 1000
+
+// dateFormats maps each --date-format value to the committerdate format
+// for-each-ref should use.
+var dateFormats = map[string]string{
+	"relative": "relative",
+	"iso":      "iso",
+	"short":    "short",
+}
+
+// dateFormatNames are the accepted --date-format values.
+var dateFormatNames = []string{"relative", "iso", "short"}
+
+// getRecentBranches lists local (or remote) branches, most recently
+// committed first unless q asks for another order. A positive limit caps
+// how many are returned.
+func getRecentBranches(q branchQuery) ([]branchInfo, error) {
+	keys, ok := sortKeys[q.sort]
+	if !ok {
+		keys = sortKeys["-date"]
+	}
+	dates, ok := dateFormats[q.dates]
+	if !ok {
+		dates = "relative"
+	}
+	date := "committerdate"
+	if q.tags {
+		// Annotated tags have no committer date, only the tagger's, which
+		// creatordate falls back to
+		date = "creatordate"
+		keys = slices.Clone(keys)
+		for i, key := range keys {
+			keys[i] = strings.Replace(key, "committerdate", date, 1)
+		}
+	}
+	args := append([]string{"for-each-ref"}, keys...)
+	args = append(args, "--format=%(refname)%09%(refname:short)%09%("+date+":"+dates+")%09%(upstream:track)%09%(authorname)%09%(authoremail)%09%("+date+":unix)%09%(contents:subject)")
+	// Duplicates and excluded branches are dropped below and the checkout
+	// order is applied there too, so in those cases the limit is applied
+	// afterwards
+	limitAfter := q.all || len(q.exclude) > 0 || q.email != "" || q.sort == "checkout"
+	if q.limit > 0 && !limitAfter {
+		args = append(args, fmt.Sprintf("--count=%d", q.limit))
+	}
+	remotes := "refs/remotes/"
+	if q.remoteName != "" {
+		remotes += q.remoteName + "/"
+	}
+	switch {
+	case q.all:
+		args = append(args, "refs/heads/", remotes)
+	case q.remote:
+		args = append(args, remotes)
+	default:
+		args = append(args, "refs/heads/")
 	}
-	output, err := cmd.Output()
+	if q.tags {
+		args = append(args, "refs/tags/")
+	}
+	output, err := git.Output(args...)
 	if err != nil {
 		return nil, err
 	}
+	// Looked up once so remote refs can be split without scanning every
+	// remote for each of them
+	var remoteNames map[string]bool
+	if q.remote || q.all {
+		names, _ := listRemotes()
+		remoteNames = make(map[string]bool, len(names))
+		for _, name := range names {
+			remoteNames[name] = true
+		}
+	}
 
-	branches := strings.Split(strings.TrimSpace(string(output)), "\n")
-	var filtered []string
-	for _, b := range branches {
-		if b != "" && !strings.HasSuffix(b, "/HEAD") {
-			filtered = append(filtered, b)
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	filtered := make([]branchInfo, 0, len(lines))
+	for _, line := range lines {
+		fields := strings.SplitN(line, "\t", 8)
+		if len(fields) < 2 {
+			continue
+		}
+		name := fields[1]
+		if name == "" || strings.HasSuffix(name, "/HEAD") {
+			continue
+		}
+		b := branchInfo{
+			name:   name,
+			remote: strings.HasPrefix(fields[0], "refs/remotes/"),
+			tag:    strings.HasPrefix(fields[0], "refs/tags/"),
+		}
+		if b.remote {
+			b.remoteName = remoteOf(name, remoteNames)
+		}
+		if len(fields) > 2 {
+			b.lastCommit = fields[2]
 		}
+		if len(fields) > 3 {
+			b.ahead, b.behind = parseTrack(fields[3])
+			// Empty without an upstream, so only branches that had one
+			b.gone = fields[3] == "[gone]"
+		}
+		if len(fields) > 4 {
+			b.author = fields[4]
+		}
+		if len(fields) > 5 {
+			b.email = strings.Trim(fields[5], "<>")
+		}
+		if len(fields) > 6 {
+			b.committed, _ = strconv.ParseInt(fields[6], 10, 64)
+		}
+		if len(fields) > 7 {
+			b.subject = fields[7]
+		}
+		if q.email != "" && !strings.EqualFold(b.email, q.email) {
+			continue
+		}
+		filtered = append(filtered, b)
+	}
+
+	if q.base != "" {
+		markMerged(filtered, q.base)
+	}
+
+	if q.all {
+		filtered = dedupeRemotes(filtered)
+	}
+	if len(q.exclude) > 0 {
+		filtered = excludeBranches(filtered, q.exclude)
+	}
+	if q.sort == "checkout" {
+		sortByCheckout(filtered)
+	}
+	if limitAfter && q.limit > 0 && len(filtered) > q.limit {
+		filtered = filtered[:q.limit]
 	}
 	return filtered, nil
 }
 
-func initialModel(remote bool) model {
-	branches, err := getRecentBranches(remote)
-	return model{
-		branches:        branches,
-		allBranches:     branches,
-		cursor:          0,
-		offset:          0,
-		remote:          remote,
-		selected:        false,
-		err:             err,
-		filterMode:      false,
-		filterText:      "",
-		filteredApplied: false,
+// sortByCheckout orders branches by when they were last checked out,
+// according to the HEAD reflog. Branches not checked out within the last
+// reflogWindow entries keep their order after those that were.
+func sortByCheckout(branches []branchInfo) {
+	output, err := git.Output("reflog", "-n", fmt.Sprint(reflogWindow), "--format=%gs")
+	if err != nil {
+		return
+	}
+	rank := map[string]int{}
+	for _, line := range strings.Split(string(output), "\n") {
+		rest, ok := strings.CutPrefix(line, "checkout: moving from ")
+		if !ok {
+			continue
+		}
+		if _, to, ok := strings.Cut(rest, " to "); ok {
+			if _, seen := rank[to]; !seen {
+				rank[to] = len(rank)
+			}
+		}
 	}
+	sort.SliceStable(branches, func(i, j int) bool {
+		ri, iok := rank[branches[i].name]
+		rj, jok := rank[branches[j].name]
+		if iok && jok {
+			return ri < rj
+		}
+		return iok && !jok
+	})
 }
 
-func (m model) Init() tea.Cmd {
-	return nil
+// markMerged flags the branches whose tip is reachable from base, other
+// than base itself and its remote-tracking counterparts.
+func markMerged(branches []branchInfo, base string) {
+	output, err := git.Output("for-each-ref", "--merged="+base, "--format=%(refname:short)", "refs/heads/", "refs/remotes/")
+	if err != nil {
+		return
+	}
+	merged := map[string]bool{}
+	for _, name := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		merged[name] = true
+	}
+	for i, b := range branches {
+		if b.name == base || b.remote && strings.HasSuffix(b.name, "/"+base) {
+			continue
+		}
+		branches[i].merged = merged[b.name]
+	}
 }
 
-func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		// Handle filter mode
-		if m.filterMode {
-			switch msg.String() {
-			case "esc":
-				// Cancel filter mode and restore original list
-				m.filterMode = false
-				m.filterText = ""
-				m.branches = m.allBranches
-				m.cursor = 0
-				m.offset = 0
-				m.filteredApplied = false
-			case "enter":
-				// Keep the filtered list and exit filter mode
-				m.filterMode = false
-				m.filteredApplied = true
-			case "backspace":
-				if len(m.filterText) > 0 {
-					m.filterText = m.filterText[:len(m.filterText)-1]
-					m.applyFilter()
-				}
-			default:
-				// Add character to filter
-				if len(msg.String()) == 1 {
-					m.filterText += msg.String()
-					m.applyFilter()
-				}
-			}
-			return m, nil
+// defaultBranch guesses the branch work is merged into, unless --base
+// names it: the branch origin's HEAD points at, else init.defaultBranch,
+// else a local main or master. It returns an empty string if none is
+// found.
+func defaultBranch() string {
+	var candidates []string
+	if output, err := git.Output("symbolic-ref", "--short", "refs/remotes/origin/HEAD"); err == nil {
+		ref := strings.TrimSpace(string(output))
+		// Prefer the local branch when there is one
+		candidates = append(candidates, strings.TrimPrefix(ref, "origin/"), ref)
+	}
+	if output, err := git.Output("config", "init.defaultBranch"); err == nil {
+		candidates = append(candidates, strings.TrimSpace(string(output)))
+	}
+	candidates = append(candidates, "main", "master")
+	for _, name := range candidates {
+		if branchExists(name) {
+			return name
 		}
+	}
+	return ""
+}
 
-		// Normal mode
-		switch msg.String() {
-		case "ctrl+c", "q":
-			return m, tea.Quit
+// branchExists reports whether name resolves to a commit.
+func branchExists(name string) bool {
+	_, err := git.Output("rev-parse", "--verify", "--quiet", name+"^{commit}")
+	return err == nil
+}
 
-		case "esc":
-			// Clear filter if one is applied, otherwise quit
-			if m.filteredApplied {
-				m.branches = m.allBranches
-				m.filterText = ""
-				m.cursor = 0
-				m.offset = 0
-				m.filteredApplied = false
-			} else {
-				return m, tea.Quit
+// excludeBranches drops branches matching any of the glob patterns. Remote
+// branches are also matched without their remote name, so "dependabot/*"
+// hides "origin/dependabot/npm" too.
+func excludeBranches(branches []branchInfo, patterns []string) []branchInfo {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		res[i] = globRegexp(p)
+	}
+	var kept []branchInfo
+	for _, b := range branches {
+		names := []string{b.name}
+		if name, ok := b.remoteBranch(); ok {
+			names = append(names, name)
+		}
+		if !matchesAny(res, names) {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+func matchesAny(res []*regexp.Regexp, names []string) bool {
+	for _, re := range res {
+		for _, name := range names {
+			if re.MatchString(name) {
+				return true
 			}
+		}
+	}
+	return false
+}
 
-		case "/":
-			// Enter filter mode
-			m.filterMode = true
-			m.filterText = ""
+// globRegexp converts a glob to an anchored regular expression. "*" matches
+// any run of characters, including "/", and "?" matches one character.
+func globRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
 
-		case "up", "k":
-			if m.cursor > 0 {
-				m.cursor--
-				if m.cursor < m.offset {
-					m.offset--
-				}
-			}
+// dedupeRemotes drops remote-tracking branches that have a local branch of
+// the same name, so each branch is listed once.
+func dedupeRemotes(branches []branchInfo) []branchInfo {
+	local := map[string]bool{}
+	for _, b := range branches {
+		if !b.remote {
+			local[b.name] = true
+		}
+	}
 
-		case "down", "j":
-			if m.cursor < len(m.branches)-1 {
-				m.cursor++
-				if m.cursor >= m.offset+10 {
-					m.offset++
-				}
-			}
+	deduped := make([]branchInfo, 0, len(branches))
+	for _, b := range branches {
+		if name, ok := b.remoteBranch(); ok && local[name] {
+			continue
+		}
+		deduped = append(deduped, b)
+	}
+	return deduped
+}
 
-		case "enter":
-			m.selected = true
-			return m, tea.Quit
+// parseTrack reads git's %(upstream:track) output, e.g. "[ahead 3, behind 1]".
+func parseTrack(track string) (ahead, behind int) {
+	track = strings.Trim(track, "[]")
+	if track == "" {
+		return 0, 0
+	}
+	for _, part := range strings.Split(track, ", ") {
+		if n, ok := strings.CutPrefix(part, "ahead "); ok {
+			ahead, _ = strconv.Atoi(n)
+		} else if n, ok := strings.CutPrefix(part, "behind "); ok {
+			behind, _ = strconv.Atoi(n)
 		}
 	}
+	return ahead, behind
+}
 
-	return m, nil
+// trackLabel renders ahead/behind counts like "↑3 ↓1" and merged and gone
+// badges, or an empty string when there is nothing to show.
+func trackLabel(b branchInfo) string {
+	if b.tag {
+		return "tag"
+	}
+	var parts []string
+	if b.ahead > 0 {
+		parts = append(parts, fmt.Sprintf("↑%d", b.ahead))
+	}
+	if b.behind > 0 {
+		parts = append(parts, fmt.Sprintf("↓%d", b.behind))
+	}
+	if b.gone {
+		parts = append(parts, "gone")
+	}
+	if b.merged {
+		parts = append(parts, "merged")
+	}
+	return strings.Join(parts, " ")
 }
 
-func (m *model) applyFilter() {
-	if m.filterText == "" {
-		m.branches = m.allBranches
-		m.cursor = 0
-		m.offset = 0
-		return
+// isInsideWorkTree reports whether the working directory is inside a git
+// work tree. It only fails if git timed out.
+func isInsideWorkTree() (bool, error) {
+	output, err := git.Output("rev-parse", "--is-inside-work-tree")
+	if errors.Is(err, errGitTimeout) {
+		return false, err
 	}
+	return err == nil && strings.TrimSpace(string(output)) == "true", nil
+}
 
-	var filtered []string
-	filterLower := strings.ToLower(m.filterText)
-	for _, branch := range m.allBranches {
-		if strings.Contains(strings.ToLower(branch), filterLower) {
-			filtered = append(filtered, branch)
-		}
+// repoRoot returns the top level directory of the work tree.
+func repoRoot() (string, error) {
+	output, err := git.Output("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
 	}
-	m.branches = filtered
-	m.cursor = 0
-	m.offset = 0
+	return strings.TrimSpace(string(output)), nil
 }
 
-func (m model) View() string {
-	if m.err != nil {
-		return fmt.Sprintf("Error: %v\n", m.err)
+// currentBranch returns the name of the checked out branch, or an empty
+// string when HEAD is detached.
+func currentBranch() (string, error) {
+	output, err := git.Output("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", err
 	}
+	name := strings.TrimSpace(string(output))
+	if name == "HEAD" {
+		return "", nil
+	}
+	return name, nil
+}
 
-	if len(m.branches) == 0 {
-		if m.filterMode {
-			return fmt.Sprintf("No branches match filter.\n\nFilter: /%s_\n\n(type to filter, enter to keep, esc to cancel)\n", m.filterText)
+// otherWorktrees maps each branch checked out in a worktree other than the
+// one at root to that worktree's path.
+func otherWorktrees(root string) (map[string]string, error) {
+	output, err := git.Output("worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	branches := map[string]string{}
+	path := ""
+	for _, line := range strings.Split(string(output), "\n") {
+		if p, ok := strings.CutPrefix(line, "worktree "); ok {
+			path = p
+		} else if ref, ok := strings.CutPrefix(line, "branch "); ok && path != root {
+			branches[strings.TrimPrefix(ref, "refs/heads/")] = path
 		}
-		return "No branches found.\n"
 	}
+	return branches, nil
+}
 
-	s := "Select a branch to checkout:\n\n"
+// strandedHead reports whether HEAD is detached at a commit that no branch
+// or tag contains, so checking out something else would leave it behind.
+func strandedHead() bool {
+	if _, err := git.Output("symbolic-ref", "-q", "HEAD"); err == nil {
+		return false
+	}
+	output, err := git.Output("for-each-ref", "--contains=HEAD", "--count=1", "--format=%(refname)", "refs/heads/", "refs/remotes/", "refs/tags/")
+	return err == nil && strings.TrimSpace(string(output)) == ""
+}
 
-	selectedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("205")).Bold(true)
-	cursorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+// ageStyle returns the style for b's date, colored by how long ago it was
+// last committed to.
+func (m model) ageStyle(b branchInfo) lipgloss.Style {
+	if b.committed == 0 {
+		return m.theme.dim
+	}
+	age := time.Since(time.Unix(b.committed, 0))
+	switch {
+	case age >= m.staleAfter:
+		return m.theme.stale
+	case age >= m.agingAfter:
+		return m.theme.aging
+	}
+	return m.theme.fresh
+}
 
-	end := m.offset + 10
-	if end > len(m.branches) {
-		end = len(m.branches)
+// unpushedCommits returns how many commits HEAD has that its upstream
+// doesn't, or 0 when there is no upstream.
+func unpushedCommits() int {
+	output, err := git.Output("rev-list", "--count", "@{u}..HEAD")
+	if err != nil {
+		return 0
 	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(output)))
+	return n
+}
 
-	for i := m.offset; i < end; i++ {
-		branch := m.branches[i]
-		cursor := " "
-		if m.cursor == i {
-			cursor = cursorStyle.Render("›")
-			branch = selectedStyle.Render(branch)
-		}
-		s += fmt.Sprintf("%s %s\n", cursor, branch)
+// unpushedWarning describes the current branch's unpushed commits, or
+// returns "" when there are none or b is the current branch.
+func (m model) unpushedWarning(b branchInfo) string {
+	if m.unpushed == 0 || (b.name == m.current && !b.remote) {
+		return ""
+	}
+	commits := "commits"
+	if m.unpushed == 1 {
+		commits = "commit"
 	}
+	return fmt.Sprintf("%s has %d unpushed %s", m.current, m.unpushed, commits)
+}
 
-	s += "\n"
+// defaultPageSize is the number of visible branches when neither --height
+// nor the terminal size tells us otherwise.
+const defaultPageSize = 10
 
-	if m.filterMode {
-		s += fmt.Sprintf("Filter: /%s_\n", m.filterText)
-		s += "(type to filter, enter to keep, esc to cancel)\n"
-	} else if m.filteredApplied {
-		s += fmt.Sprintf("[Filtered: %s] ", m.filterText)
-		s += "(/ to filter, esc to clear, j/k to move, enter to select, q to quit)\n"
-	} else {
-		s += "(/ to filter, j/k to move, enter to select, q to quit)\n"
+// headerLines is the number of lines View draws above the branch list.
+const headerLines = 2
+
+// chromeLines is the number of lines View draws around the branch list.
+const chromeLines = 7
+
+// compactChromeLines is chromeLines for the compact layout, which leaves
+// out the header, the scroll indicators and the help line.
+const compactChromeLines = 4
+
+// previewCommits is how many commits the preview panel shows.
+const previewCommits = 5
+
+func initialModel(cfg config) model {
+	query := cfg.query()
+	current, _ := currentBranch()
+	root, _ := repoRoot()
+	stranded := strandedHead()
+	format, _ := parseRowFormat(cfg.format)
+	unpushed := unpushedCommits()
+	worktrees, _ := otherWorktrees(root)
+	remotes, _ := listRemotes()
+	pins := map[string]bool{}
+	for _, name := range cfg.pins[root] {
+		pins[name] = true
+	}
+	filter := ""
+	if cfg.rememberFilter {
+		filter = loadFilters()[root]
+	}
+	pageSize := defaultPageSize
+	if cfg.height > 0 {
+		pageSize = cfg.height
+	}
+	return model{
+		cursor:          0,
+		offset:          0,
+		pageSize:        pageSize,
+		fixedHeight:     cfg.height > 0,
+		query:           query,
+		keys:            cfg.keys,
+		group:           cfg.group,
+		collapsed:       map[string]bool{},
+		selectOnly:      cfg.selectOnly,
+		dryRun:          cfg.dryRun,
+		worktree:        cfg.worktree,
+		loading:         true,
+		fetching:        cfg.fetch,
+		spinner:         spinner.New(spinner.WithSpinner(spinner.MiniDot)),
+		current:         current,
+		strandedHead:    stranded,
+		unpushed:        unpushed,
+		agingAfter:      time.Duration(cfg.agingDays) * 24 * time.Hour,
+		staleAfter:      time.Duration(cfg.staleDays) * 24 * time.Hour,
+		theme:           selectTheme(cfg.theme),
+		repoRoot:        root,
+		pins:            pins,
+		marked:          map[string]bool{},
+		worktrees:       worktrees,
+		remotes:         remotes,
+		selected:        false,
+		previews:        map[string][]string{},
+		showSubjects:    true,
+		compact:         cfg.compact,
+		format:          format,
+		highlightRow:    cfg.highlightRow,
+		filterMode:      false,
+		filterText:      filter,
+		filterCaret:     utf8.RuneCountInString(filter),
+		filteredApplied: filter != "",
+		rememberFilter:  cfg.rememberFilter,
+		liveFilter:      cfg.liveFilter,
+		stashPop:        cfg.stashPop,
 	}
+}
 
-	return s
+// branchesLoadedMsg carries the result of listing branches.
+type branchesLoadedMsg struct {
+	branches []branchInfo
+	err      error
 }
 
-func checkoutBranch(branch string, remote bool) error {
-	var cmd *exec.Cmd
-	if remote {
-		parts := strings.SplitN(branch, "/", 2)
-		if len(parts) == 2 {
-			localBranch := parts[1]
-			cmd = exec.Command("git", "checkout", localBranch)
-		} else {
-			cmd = exec.Command("git", "checkout", "--track", branch)
-		}
-	} else {
-		cmd = exec.Command("git", "checkout", branch)
+func loadBranches(q branchQuery) tea.Cmd {
+	return func() tea.Msg {
+		branches, err := getRecentBranches(q)
+		return branchesLoadedMsg{branches: branches, err: err}
 	}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
 }
 
-func main() {
-	remote := flag.Bool("r", false, "list remote branches")
-	flag.BoolVar(remote, "remote", false, "list remote branches")
-	flag.Parse()
+// fetchDoneMsg reports the result of fetching from remotes.
+type fetchDoneMsg struct {
+	err error
+}
 
-	p := tea.NewProgram(initialModel(*remote))
-	m, err := p.Run()
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+func fetchCmd() tea.Msg {
+	return fetchDoneMsg{err: fetchAll()}
+}
+
+// checkoutResultMsg reports the result of checking out the selection.
+type checkoutResultMsg struct {
+	log    []string // what was done, for printing after the picker exits
+	err    error    // the checkout failed and nothing was changed
+	popErr error    // the stash couldn't be reapplied, after the checkout or its failure
+}
+
+// checkoutCmd checks out b, stashing local changes first if stash is set.
+// If the checkout fails, the stash is reapplied so the work tree is left
+// as it was and another branch can be picked.
+func checkoutCmd(b branchInfo, detach, stash, stashPop bool) tea.Cmd {
+	return func() tea.Msg {
+		var log []string
+		// Tags can only be checked out detached
+		detach = detach || b.tag
+		args := checkoutArgs(b.name, b.remote, detach)
+		if stash {
+			var err error
+			if stash, err = stashChanges(); err != nil {
+				return checkoutResultMsg{err: fmt.Errorf("couldn't stash changes: %v", err)}
+			}
+			if stash {
+				log = append(log, "Stashed local changes")
+			}
+		}
+		if err := runGit(args...); err != nil {
+			var popErr error
+			if stash {
+				popErr = popStash()
+			}
+			return checkoutResultMsg{err: err, popErr: popErr}
+		}
+		switch {
+		case detach:
+			log = append(log, fmt.Sprintf("Checked out %s as a detached HEAD", b.name))
+		case slices.Contains(args, "--track"):
+			log = append(log, fmt.Sprintf("Created %s to track %s", args[2], b.name))
+		case b.remote:
+			log = append(log, fmt.Sprintf("Checked out existing local branch %s for %s", args[1], b.name))
+		default:
+			log = append(log, fmt.Sprintf("Checked out: %s", b.name))
+		}
+		if stash && stashPop {
+			if err := popStash(); err != nil {
+				return checkoutResultMsg{log: log, popErr: err}
+			}
+			log = append(log, "Reapplied stashed changes")
+		}
+		return checkoutResultMsg{log: log}
 	}
+}
 
-	finalModel := m.(model)
-	if finalModel.err != nil {
-		fmt.Printf("Error: %v\n", finalModel.err)
-		os.Exit(1)
+func (m model) Init() tea.Cmd {
+	cmds := []tea.Cmd{loadBranches(m.query), m.spinner.Tick}
+	if m.fetching {
+		cmds = append(cmds, fetchCmd)
 	}
+	return tea.Batch(cmds...)
+}
+
+// busy reports whether a background git command is running.
+func (m model) busy() bool {
+	return m.loading || m.fetching || m.checkingOut
+}
+
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.resize()
+
+	case branchesLoadedMsg:
+		m.loading = false
+		if msg.err != nil {
+			if !m.loaded {
+				m.err = msg.err
+				return m, tea.Quit
+			}
+			m.status = firstLine(msg.err.Error())
+			return m, nil
+		}
+		m.loaded = true
+		m.setBranches(msg.branches)
+		m.cancelStaleDelete()
+		m.loadPreview()
+		if m.refreshing {
+			m.refreshing = false
+			m.status = "Refreshed"
+		}
+		return m, nil
 
-	if finalModel.selected && len(finalModel.branches) > 0 {
-		selectedBranch := finalModel.branches[finalModel.cursor]
-		fmt.Printf("Checking out: %s\n", selectedBranch)
-		if err := checkoutBranch(selectedBranch, finalModel.remote); err != nil {
-			fmt.Printf("Failed to checkout branch: %v\n", err)
-			os.Exit(1)
+	case checkoutResultMsg:
+		m.checkingOut = false
+		if msg.err != nil {
+			// Stay in the picker so another branch can be chosen
+			m.selected = false
+			m.stash = false
+			m.detach = false
+			m.status = "Checkout failed: " + firstLine(msg.err.Error())
+			if msg.popErr != nil {
+				m.status += "; couldn't reapply the stash, your changes are still in it: " + firstLine(msg.popErr.Error())
+			}
+			return m, nil
 		}
-	}
+		m.checkoutLog = msg.log
+		if warning := m.unpushedWarning(m.chosen); warning != "" {
+			m.checkoutLog = append(m.checkoutLog, "Note: "+warning)
+		}
+		m.popErr = msg.popErr
+		return m, tea.Quit
+
+	case worktreeResultMsg:
+		m.checkingOut = false
+		if msg.err != nil {
+			m.selected = false
+			m.worktreeDir = ""
+			m.status = "Couldn't add worktree: " + firstLine(msg.err.Error())
+			return m, nil
+		}
+		m.checkoutLog = []string{fmt.Sprintf("Added a worktree for %s at %s", m.chosen.name, m.worktreeDir)}
+		return m, tea.Quit
+
+	case fetchDoneMsg:
+		m.fetching = false
+		if msg.err != nil {
+			m.refreshing = false
+			m.status = "Fetch failed: " + firstLine(msg.err.Error())
+			return m, nil
+		}
+		return m, m.reload()
+
+	case spinner.TickMsg:
+		if !m.busy() {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+
+	case tea.MouseMsg:
+		if m.confirm != confirmNone || m.prompt != promptNone || m.showHelp || m.info != nil {
+			return m, nil
+		}
+		return m.updateMouse(msg)
+
+	case tea.KeyMsg:
+		// Wait for a running checkout, which quits once it is done. Even
+		// ctrl+c waits, since quitting wouldn't stop git from switching
+		// branches and the exit status would claim nothing happened
+		if m.checkingOut {
+			return m, nil
+		}
+
+		if msg.String() == "esc" {
+			return m.escape()
+		}
+
+		// Handle filter mode
+		if m.filterMode {
+			switch msg.String() {
+			case "ctrl+c":
+				return m, tea.Quit
+			case "enter":
+				// Keep the filtered list and exit filter mode
+				m.filterMode = false
+				m.filteredApplied = true
+				m.persistFilter()
+			case "ctrl+s":
+				m.caseSensitive = !m.caseSensitive
+				m.applyFilter()
+			case "ctrl+r":
+				m.regexMode = !m.regexMode
+				m.applyFilter()
+			case "ctrl+t":
+				m.filterAuthor = !m.filterAuthor
+				m.applyFilter()
+			default:
+				if text, caret, ok := editText(m.filterText, m.filterCaret, msg); ok {
+					m.filterCaret = caret
+					if text != m.filterText {
+						m.filterText = text
+						m.applyFilter()
+					}
+				}
+			}
+			m.loadPreview()
+			return m, nil
+		}
+
+		// Typing into a text prompt
+		if m.prompt != promptNone {
+			return m.updatePrompt(msg)
+		}
+
+		// Answering a confirmation prompt
+		if m.confirm != confirmNone {
+			return m.updateConfirm(msg)
+		}
+
+		// The help overlay swallows keys until it is dismissed
+		if m.showHelp {
+			switch key := msg.String(); {
+			case key == "ctrl+c":
+				return m, tea.Quit
+			case m.keys.help.has(key) || m.keys.quit.has(key):
+				m.showHelp = false
+			}
+			return m, nil
+		}
+
+		// So does the branch details overlay
+		if m.info != nil {
+			switch key := msg.String(); {
+			case key == "ctrl+c":
+				return m, tea.Quit
+			case m.keys.info.has(key) || m.keys.quit.has(key):
+				m.info = nil
+			}
+			return m, nil
+		}
+
+		m.status = ""
+
+		// With live filtering, text keys edit the filter while everything
+		// else keeps its normal meaning
+		if m.liveFilter {
+			switch msg.Type {
+			case tea.KeyRunes, tea.KeySpace, tea.KeyBackspace, tea.KeyDelete, tea.KeyLeft, tea.KeyRight:
+				text, caret, _ := editText(m.filterText, m.filterCaret, msg)
+				m.filterCaret = caret
+				if text != m.filterText {
+					m.filterText = text
+					m.filteredApplied = text != ""
+					m.applyFilter()
+					m.loadPreview()
+				}
+				return m, nil
+			}
+		}
+
+		// A number typed first repeats the next movement, as in vim
+		if msg.Type == tea.KeyRunes && !msg.Alt && len(msg.Runes) == 1 && unicode.IsDigit(msg.Runes[0]) && (m.count > 0 || msg.Runes[0] != '0') {
+			m.count = min(m.count*10+int(msg.Runes[0]-'0'), 9999)
+			m.status = fmt.Sprint(m.count)
+			return m, nil
+		}
+		count := m.count
+		m.count = 0
+		repeat := max(1, count)
+		key := msg.String()
+
+		// Group headers aren't branches, so only navigation and
+		// folding apply to them
+		k := m.keys
+		if m.onHeader() && (k.detach.has(key) || k.mark.has(key) || k.delete.has(key) || k.info.has(key) || k.rename.has(key) || k.worktree.has(key) || k.open.has(key) || k.copy.has(key) || k.pin.has(key)) {
+			m.status = fmt.Sprintf("%s expands or collapses a group", k.fold.hint())
+			return m, nil
+		}
+
+		// Normal mode
+		switch {
+		case key == "ctrl+c" || m.keys.quit.has(key):
+			return m, tea.Quit
+
+		case m.keys.filter.has(key):
+			// Enter filter mode
+			m.filterMode = true
+			m.filterText = ""
+			m.filterCaret = 0
+
+		case m.keys.up.has(key):
+			m.cursor = max(m.cursor-repeat, 0)
+			m.clampOffset()
+
+		case m.keys.down.has(key):
+			m.cursor = max(0, min(m.cursor+repeat, len(m.branches)-1))
+			m.clampOffset()
+
+		case m.keys.top.has(key):
+			m.cursor = 0
+			m.offset = 0
+
+		case m.keys.bottom.has(key):
+			// With a count, jump to that row instead of the last
+			m.cursor = max(0, len(m.branches)-1)
+			if count > 0 {
+				m.cursor = min(count-1, m.cursor)
+			}
+			m.clampOffset()
+
+		case m.keys.pageDown.has(key):
+			m.cursor = max(0, min(m.cursor+repeat*m.pageSize, len(m.branches)-1))
+			m.offset = max(0, min(m.offset+repeat*m.pageSize, len(m.branches)-m.pageSize))
+			m.clampOffset()
+
+		case m.keys.pageUp.has(key):
+			m.cursor = max(m.cursor-repeat*m.pageSize, 0)
+			m.offset = max(m.offset-repeat*m.pageSize, 0)
+			m.clampOffset()
+
+		case m.keys.choose.has(key):
+			return m.choose()
+
+		case m.keys.worktree.has(key):
+			if len(m.branches) > 0 {
+				return m.chooseWorktree(m.branches[m.cursor])
+			}
+
+		case m.keys.fold.has(key):
+			m.toggleGroup()
+
+		case m.keys.back.has(key):
+			return m.chooseBack()
+
+		case m.keys.detach.has(key):
+			// A quick look at a branch without creating a local one
+			m.detach = true
+			return m.choose()
+
+		case m.keys.mark.has(key):
+			if len(m.branches) == 0 {
+				return m, nil
+			}
+			if m.branches[m.cursor].tag {
+				m.status = "Tags can't be deleted from here"
+				return m, nil
+			}
+			name := m.branches[m.cursor].name
+			if m.marked[name] {
+				delete(m.marked, name)
+			} else {
+				m.marked[name] = true
+			}
+
+		case m.keys.delete.has(key):
+			// Marked branches hidden by a filter that matches nothing
+			// aren't deleted either, since the empty list has no room
+			// to confirm it
+			if len(m.branches) == 0 {
+				return m, nil
+			}
+			if len(m.marked) > 0 {
+				m.confirm = confirmDeleteMarked
+				return m, nil
+			}
+			if m.branches[m.cursor].tag {
+				m.status = "Tags can't be deleted from here"
+				return m, nil
+			}
+			// Remembered by name, as a reload can move the cursor while
+			// the confirmation is open
+			m.deleting = m.branches[m.cursor]
+			m.confirm = confirmDelete
+
+		case m.keys.undo.has(key):
+			return m, m.undoDelete()
+
+		case m.keys.preview.has(key):
+			m.showPreview = !m.showPreview
+			m.resize()
+
+		case m.keys.subjects.has(key):
+			m.showSubjects = !m.showSubjects
+
+		case m.keys.compact.has(key):
+			m.compact = !m.compact
+			m.resize()
+
+		case m.keys.merged.has(key):
+			m.show = (m.show + 1) % 4
+			if m.query.base == "" && (m.show == showMerged || m.show == showUnmerged) {
+				// Nothing to check merges against
+				m.show = showGone
+			}
+			noBase := ""
+			if m.query.base == "" {
+				noBase = " (no base branch found to check merges against; set one with --base)"
+			}
+			switch m.show {
+			case showMerged:
+				m.status = "Showing branches merged into " + m.query.base
+			case showUnmerged:
+				m.status = "Showing branches not merged into " + m.query.base
+			case showGone:
+				m.status = "Showing branches whose upstream is gone" + noBase
+			default:
+				m.status = "Showing all branches"
+			}
+			m.applyFilter()
+			m.loadPreview()
+
+		case m.keys.help.has(key):
+			m.showHelp = true
+
+		case m.keys.info.has(key):
+			if len(m.branches) == 0 {
+				return m, nil
+			}
+			info, err := loadDetails(m.branches[m.cursor])
+			if err != nil {
+				m.status = firstLine(err.Error())
+				return m, nil
+			}
+			m.info = info
+
+		case m.keys.create.has(key):
+			m.prompt = promptNewBranch
+			m.promptText = ""
+			m.promptCaret = 0
+			m.promptErr = ""
+
+		case m.keys.rename.has(key):
+			if len(m.branches) == 0 {
+				return m, nil
+			}
+			if m.branches[m.cursor].remote {
+				m.status = "Remote branches can't be renamed"
+				return m, nil
+			}
+			if m.branches[m.cursor].tag {
+				m.status = "Tags can't be renamed"
+				return m, nil
+			}
+			m.prompt = promptRename
+			m.promptText = m.branches[m.cursor].name
+			m.promptCaret = utf8.RuneCountInString(m.promptText)
+			m.promptErr = ""
+
+		case m.keys.open.has(key):
+			if len(m.branches) == 0 {
+				return m, nil
+			}
+			if m.branches[m.cursor].tag {
+				m.status = "Pull requests need a branch, not a tag"
+				return m, nil
+			}
+			m.openPullRequest()
+
+		case m.keys.copy.has(key):
+			if len(m.branches) == 0 {
+				return m, nil
+			}
+			name := m.branches[m.cursor].name
+			if err := copyToClipboard(name); err != nil {
+				m.status = "Copy failed: " + err.Error()
+			} else {
+				m.status = "Copied " + name
+			}
+
+		case m.keys.pin.has(key):
+			if len(m.branches) == 0 {
+				return m, nil
+			}
+			m.togglePin()
+			m.resize()
+			return m, m.reload()
+
+		case m.keys.sort.has(key):
+			m.query.sort = nextSortOrder(m.query.sort)
+			m.status = "Sorted by " + m.query.sort
+			return m, m.reload()
+
+		case m.keys.refresh.has(key):
+			// Pick up branches, commits and worktrees changed elsewhere
+			m.current, _ = currentBranch()
+			m.worktrees, _ = otherWorktrees(m.repoRoot)
+			m.previews = map[string][]string{}
+			m.refreshing = true
+			return m, m.reload()
+
+		case m.keys.fetch.has(key):
+			// Fetch first, then reload as refresh does once it's done
+			if m.fetching {
+				return m, nil
+			}
+			m.fetching = true
+			m.previews = map[string][]string{}
+			m.refreshing = true
+			return m, tea.Batch(fetchCmd, m.spinner.Tick)
+		}
+	}
+
+	m.loadPreview()
+	return m, nil
+}
+
+// escape handles esc, which backs out one layer at a time: an open text
+// prompt, confirmation or overlay is dismissed first, then filter mode or
+// an applied filter is cleared, then a pending count is dropped, and only
+// with none of those left does esc quit. New overlays go at the top so esc
+// closes them rather than quitting.
+func (m model) escape() (tea.Model, tea.Cmd) {
+	switch {
+	case m.prompt != promptNone:
+		m.prompt = promptNone
+	case m.confirm != confirmNone:
+		m.confirm = confirmNone
+		m.detach = false
+	case m.showHelp:
+		m.showHelp = false
+	case m.info != nil:
+		m.info = nil
+	case m.filterMode || m.filteredApplied:
+		m.filterMode = false
+		m.filterText = ""
+		m.filterCaret = 0
+		m.applyFilter()
+		m.filteredApplied = false
+		m.persistFilter()
+	case m.count > 0:
+		m.count = 0
+		m.status = ""
+	default:
+		return m, tea.Quit
+	}
+	m.loadPreview()
+	return m, nil
+}
+
+func (m model) updatePrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "enter":
+		return m.submitPrompt()
+	}
+	if text, caret, ok := editText(m.promptText, m.promptCaret, msg); ok {
+		m.promptText = text
+		m.promptCaret = caret
+		m.promptErr = ""
+	}
+	return m, nil
+}
+
+func (m model) submitPrompt() (tea.Model, tea.Cmd) {
+	name := m.promptText
+	switch {
+	case name == "":
+		m.promptErr = "branch name can't be empty"
+		return m, nil
+	case strings.ContainsAny(name, " \t"):
+		m.promptErr = "branch name can't contain spaces"
+		return m, nil
+	}
+
+	switch m.prompt {
+	case promptNewBranch:
+		if m.dryRun {
+			// Only described once the picker exits
+			if localBranchExists(name) {
+				m.promptErr = fmt.Sprintf("a branch named %s already exists", name)
+				return m, nil
+			}
+			m.prompt = promptNone
+			m.created = name
+			return m, tea.Quit
+		}
+		if err := createBranch(name); err != nil {
+			m.promptErr = firstLine(err.Error())
+			return m, nil
+		}
+		m.prompt = promptNone
+		m.created = name
+		return m, tea.Quit
+
+	case promptRename:
+		old := m.branches[m.cursor].name
+		if name == old {
+			m.prompt = promptNone
+			return m, nil
+		}
+		if localBranchExists(name) {
+			m.promptErr = fmt.Sprintf("a branch named %s already exists", name)
+			return m, nil
+		}
+		if err := renameBranch(old, name); err != nil {
+			m.promptErr = firstLine(err.Error())
+			return m, nil
+		}
+		m.prompt = promptNone
+		m.status = fmt.Sprintf("Renamed %s to %s", old, name)
+		m.renameBranch(old, name)
+	}
+	return m, nil
+}
+
+// editText applies a text editing key to s, whose caret is at rune offset
+// caret. It returns the new text and caret, and reports false if the key
+// doesn't edit text.
+func editText(s string, caret int, msg tea.KeyMsg) (string, int, bool) {
+	r := []rune(s)
+	caret = max(0, min(caret, len(r)))
+	switch msg.Type {
+	case tea.KeyBackspace:
+		if caret > 0 {
+			r = slices.Delete(r, caret-1, caret)
+			caret--
+		}
+	case tea.KeyDelete:
+		if caret < len(r) {
+			r = slices.Delete(r, caret, caret+1)
+		}
+	case tea.KeyLeft:
+		caret = max(0, caret-1)
+	case tea.KeyRight:
+		caret = min(len(r), caret+1)
+	case tea.KeyHome, tea.KeyCtrlA:
+		caret = 0
+	case tea.KeyEnd, tea.KeyCtrlE:
+		caret = len(r)
+	case tea.KeySpace:
+		r = slices.Insert(r, caret, ' ')
+		caret++
+	case tea.KeyRunes:
+		// Alt combinations are shortcuts, not text. Checking the key type
+		// rather than the length of msg.String() also keeps names like
+		// "ctrl+a" out while letting multi-byte characters in.
+		if msg.Alt {
+			return s, caret, false
+		}
+		var typed []rune
+		for _, c := range msg.Runes {
+			if unicode.IsPrint(c) {
+				typed = append(typed, c)
+			}
+		}
+		r = slices.Insert(r, caret, typed...)
+		caret += len(typed)
+	default:
+		return s, caret, false
+	}
+	return string(r), caret, true
+}
+
+// withCaret renders text with a caret at rune offset caret: a trailing _
+// at the end, otherwise the character under it in reverse video, or a |
+// before it when the terminal can't show styles.
+func withCaret(text string, caret int) string {
+	r := []rune(text)
+	if caret >= len(r) {
+		return text + "_"
+	}
+	under := string(r[caret])
+	if styled := lipgloss.NewStyle().Reverse(true).Render(under); styled != under {
+		under = styled
+	} else {
+		under = "|" + under
+	}
+	return string(r[:caret]) + under + string(r[caret+1:])
+}
+
+// choose selects the highlighted branch, asking for confirmation first if
+// the working tree has uncommitted changes.
+func (m model) choose() (tea.Model, tea.Cmd) {
+	if len(m.branches) == 0 {
+		return m, nil
+	}
+	if m.onHeader() {
+		m.toggleGroup()
+		return m, nil
+	}
+	if m.worktree {
+		return m.chooseWorktree(m.branches[m.cursor])
+	}
+	return m.chooseBranch(m.branches[m.cursor])
+}
+
+// chooseBranch is choose for any branch, listed or not.
+func (m model) chooseBranch(b branchInfo) (tea.Model, tea.Cmd) {
+	m.chosen = b
+	name := b.name
+	if path, ok := m.worktrees[name]; ok && !m.selectOnly {
+		m.status = fmt.Sprintf("%s is checked out in another worktree: %s", name, path)
+		return m, nil
+	}
+	if m.strandedHead && !m.selectOnly {
+		m.confirm = confirmDetached
+		return m, nil
+	}
+	if dirty, _ := hasUncommittedChanges(); dirty && !m.selectOnly {
+		m.confirm = confirmDirty
+		return m, nil
+	}
+	return m.selectBranch()
+}
+
+// selectBranch finishes with the chosen branch. It is checked out in the
+// background, keeping the picker open in case that fails, unless it is
+// only to be printed or described.
+func (m model) selectBranch() (tea.Model, tea.Cmd) {
+	m.selected = true
+	if m.selectOnly || m.dryRun {
+		return m, tea.Quit
+	}
+	m.checkingOut = true
+	m.status = "Checking out " + m.chosen.name
+	return m, tea.Batch(checkoutCmd(m.chosen, m.detach, m.stash, m.stashPop), m.spinner.Tick)
+}
+
+// chooseBack chooses the branch checked out before the current one, like
+// git checkout -.
+func (m model) chooseBack() (tea.Model, tea.Cmd) {
+	name, err := previousBranch()
+	if err != nil {
+		m.status = "No previous branch to switch back to"
+		return m, nil
+	}
+	for _, b := range m.allBranches {
+		if b.name == name && !b.remote {
+			return m.chooseBranch(b)
+		}
+	}
+	return m.chooseBranch(branchInfo{name: name})
+}
+
+// doubleClickTime is the longest gap between two clicks on the same row
+// that still counts as a double click.
+const doubleClickTime = 500 * time.Millisecond
+
+// updateMouse moves the cursor to a clicked row, selects on double click and
+// scrolls with the wheel.
+func (m model) updateMouse(msg tea.MouseMsg) (tea.Model, tea.Cmd) {
+	switch msg.Button {
+	case tea.MouseButtonWheelUp:
+		if m.offset > 0 {
+			m.offset--
+			m.cursor = min(m.cursor, m.offset+m.pageSize-1)
+		}
+
+	case tea.MouseButtonWheelDown:
+		if m.offset+m.pageSize < len(m.branches) {
+			m.offset++
+			m.cursor = max(m.cursor, m.offset)
+		}
+
+	case tea.MouseButtonLeft:
+		if msg.Action != tea.MouseActionPress {
+			return m, nil
+		}
+		// Rows are counted from the top of the view, below the header
+		line := msg.Y - headerLines
+		if m.compact {
+			line = msg.Y
+		}
+		if sep := m.pinSeparator(); sep > m.offset && line >= sep-m.offset {
+			if line == sep-m.offset {
+				return m, nil
+			}
+			line--
+		}
+		row := m.offset + line
+		if line < 0 || row >= min(m.offset+m.pageSize, len(m.branches)) {
+			return m, nil
+		}
+		now := time.Now()
+		double := row == m.lastClickRow && now.Sub(m.lastClick) < doubleClickTime
+		m.cursor = row
+		m.lastClick = now
+		m.lastClickRow = row
+		if double && !m.filterMode {
+			return m.choose()
+		}
+	}
+
+	m.loadPreview()
+	return m, nil
+}
+
+func (m model) updateConfirm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "n":
+		m.confirm = confirmNone
+		m.detach = false
+		return m, nil
+	}
+
+	switch m.confirm {
+	case confirmDetached:
+		if msg.String() == "y" {
+			// Asked once; carry on with the usual checks
+			m.confirm = confirmNone
+			m.strandedHead = false
+			return m.chooseBranch(m.chosen)
+		}
+
+	case confirmDirty:
+		switch msg.String() {
+		case "y":
+			m.confirm = confirmNone
+			return m.selectBranch()
+		case "s":
+			m.confirm = confirmNone
+			m.stash = true
+			return m.selectBranch()
+		}
+
+	case confirmDelete, confirmForceDelete:
+		switch msg.String() {
+		case "y":
+			m.deleteSelected(m.confirm == confirmForceDelete)
+			m.loadPreview()
+		case "r":
+			if m.deleting.remote {
+				m.confirm = confirmPushDelete
+			}
+		}
+
+	case confirmPushDelete:
+		if msg.String() == "y" {
+			m.deleteOnRemote()
+			m.loadPreview()
+		}
+
+	case confirmDeleteMarked:
+		if msg.String() == "y" {
+			m.deleteMarked()
+			m.loadPreview()
+		}
+	}
+
+	return m, nil
+}
+
+// loadPreview fetches recent commits for the highlighted branch the first
+// time it is shown in the preview panel.
+func (m *model) loadPreview() {
+	if !m.showPreview || len(m.branches) == 0 || m.onHeader() {
+		return
+	}
+	name := m.branches[m.cursor].name
+	if _, ok := m.previews[name]; ok {
+		return
+	}
+	commits, err := recentCommits(name, previewCommits)
+	if err != nil {
+		commits = []string{firstLine(err.Error())}
+	}
+	m.previews[name] = commits
+}
+
+// deleteSelected deletes the branch d was pressed on. If git refuses
+// because the branch is not fully merged, it asks whether to force the
+// delete instead.
+func (m *model) deleteSelected(force bool) {
+	name := m.deleting.name
+	remote := m.deleting.remote
+	m.confirm = confirmNone
+	tip, err := branchTip(name, remote)
+	if err != nil {
+		m.status = err.Error()
+		return
+	}
+	if remote {
+		if err := deleteRemoteTracking(name); err != nil {
+			m.status = firstLine(err.Error())
+			return
+		}
+		m.rememberDeleted(name, remote, tip)
+		m.removeBranch(name)
+		m.status = fmt.Sprintf("Deleted remote-tracking branch %s", name) + m.undoHint()
+		return
+	}
+	if err := deleteBranch(name, force); err != nil {
+		msg := firstLine(err.Error())
+		if !force && strings.Contains(msg, "not fully merged") {
+			m.deleteErr = msg
+			m.confirm = confirmForceDelete
+			return
+		}
+		m.status = msg
+		return
+	}
+	m.rememberDeleted(name, remote, tip)
+	m.removeBranch(name)
+	m.status = fmt.Sprintf("Deleted branch %s", name) + m.undoHint()
+}
+
+// deleteMarked deletes every marked branch without forcing, then reports
+// which ones git refused.
+func (m *model) deleteMarked() {
+	m.confirm = confirmNone
+	var deleted int
+	var failed []string
+	for _, name := range m.markedNames() {
+		remote := false
+		for _, b := range m.allBranches {
+			if b.name == name {
+				remote = b.remote
+			}
+		}
+		tip, err := branchTip(name, remote)
+		if err == nil && remote {
+			err = deleteRemoteTracking(name)
+		} else if err == nil {
+			err = deleteBranch(name, false)
+		}
+		if err != nil {
+			reason := firstLine(err.Error())
+			if strings.Contains(reason, "not fully merged") {
+				reason = "not fully merged"
+			}
+			failed = append(failed, fmt.Sprintf("%s (%s)", name, reason))
+			continue
+		}
+		m.rememberDeleted(name, remote, tip)
+		m.removeBranch(name)
+		deleted++
+	}
+	m.marked = map[string]bool{}
+
+	m.status = fmt.Sprintf("Deleted %d of %d branches", deleted, deleted+len(failed))
+	if len(failed) > 0 {
+		m.status += "; couldn't delete " + strings.Join(failed, ", ")
+	}
+	if deleted > 0 {
+		m.status += m.undoHint()
+	}
+}
+
+// cancelStaleDelete closes a delete confirmation whose branch a reload
+// dropped, say after a fetch pruned it, rather than leave it to delete
+// whatever took its place. An emptied list has nowhere to show any
+// confirmation, so those are closed too.
+func (m *model) cancelStaleDelete() {
+	switch m.confirm {
+	case confirmDelete, confirmForceDelete, confirmPushDelete:
+		listed := slices.ContainsFunc(m.allBranches, func(b branchInfo) bool {
+			return b.name == m.deleting.name && b.remote == m.deleting.remote
+		})
+		if !listed {
+			m.confirm = confirmNone
+			m.status = m.deleting.name + " is gone, so it wasn't deleted"
+		}
+	}
+	if m.confirm == confirmDeleteMarked && len(m.branches) == 0 {
+		m.confirm = confirmNone
+	}
+}
+
+// markedNames returns the marked branches in list order, followed by any
+// that aren't listed right now.
+func (m model) markedNames() []string {
+	var names []string
+	for _, b := range m.allBranches {
+		if m.marked[b.name] {
+			names = append(names, b.name)
+		}
+	}
+	var rest []string
+	for name := range m.marked {
+		if !slices.Contains(names, name) {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
+// deleteOnRemote deletes the remote branch d was pressed on from the
+// remote itself.
+func (m *model) deleteOnRemote() {
+	ref := m.deleting.name
+	m.confirm = confirmNone
+	remotes, _ := listRemotes()
+	remote, branch, ok := splitRemoteRef(ref, remotes)
+	if !ok {
+		m.status = fmt.Sprintf("Couldn't tell which remote %s belongs to", ref)
+		return
+	}
+	if err := deleteOnRemote(remote, branch); err != nil {
+		m.status = firstLine(err.Error())
+		return
+	}
+	m.removeBranch(ref)
+	m.status = fmt.Sprintf("Deleted %s from %s", branch, remote)
+}
+
+// reload re-lists branches in the background with the current query.
+func (m *model) reload() tea.Cmd {
+	m.loading = true
+	return tea.Batch(loadBranches(m.query), m.spinner.Tick)
+}
+
+// setBranches replaces the branch list and re-applies any filter, keeping
+// the cursor on the same branch when it is still listed.
+func (m *model) setBranches(branches []branchInfo) {
+	selected := m.highlighted()
+	// Lowercased here rather than on every keystroke of a filter
+	for i, b := range branches {
+		branches[i].foldName = strings.ToLower(b.name)
+		branches[i].foldAuthor = strings.ToLower(b.author)
+	}
+	m.allBranches = pinnedFirst(branches, m.pins)
+	m.applyFilter()
+	m.highlight(selected)
+}
+
+// highlighted returns the name of the branch under the cursor, or an empty
+// string if the list is empty.
+func (m model) highlighted() string {
+	if len(m.branches) == 0 {
+		return ""
+	}
+	return m.branches[m.cursor].name
+}
+
+// highlight moves the cursor to the named branch if it is listed.
+func (m *model) highlight(name string) {
+	for i, b := range m.branches {
+		if b.name == name {
+			m.cursor = i
+			break
+		}
+	}
+	m.clampOffset()
+}
+
+// pinnedFirst moves pinned branches to the top, keeping the order within
+// each group.
+func pinnedFirst(branches []branchInfo, pins map[string]bool) []branchInfo {
+	if len(pins) == 0 {
+		return branches
+	}
+	sorted := make([]branchInfo, 0, len(branches))
+	for _, b := range branches {
+		if pins[b.name] {
+			sorted = append(sorted, b)
+		}
+	}
+	for _, b := range branches {
+		if !pins[b.name] {
+			sorted = append(sorted, b)
+		}
+	}
+	return sorted
+}
+
+// displayName is splitName with the branches of a group indented under
+// its header.
+func (m model) displayName(b branchInfo) (remote, name string) {
+	remote, name = m.splitName(b)
+	if m.grouped() && m.groupOf(b) != "" {
+		name = "  " + name
+	}
+	return remote, name
+}
+
+// splitName returns the remote and branch parts of b's name as displayed.
+// In -r mode the remote gets a column of its own, except in the compact
+// layout; otherwise the remote is empty and the name is shown whole.
+func (m model) splitName(b branchInfo) (remote, name string) {
+	if m.query.remote && !m.query.all && !m.compact {
+		if name, ok := b.remoteBranch(); ok {
+			return b.remoteName, name
+		}
+	}
+	return "", b.name
+}
+
+// pinSeparator returns the index of the first unpinned branch when a line
+// separates it from the pinned ones, or -1. Filtered lists are ranked by
+// match, so they aren't split.
+func (m model) pinSeparator() int {
+	if m.filterText != "" || m.group || len(m.branches) == 0 || !m.pins[m.branches[0].name] {
+		return -1
+	}
+	for i, b := range m.branches {
+		if !m.pins[b.name] {
+			return i
+		}
+	}
+	return -1
+}
+
+// togglePin pins or unpins the highlighted branch and saves the change.
+func (m *model) togglePin() {
+	name := m.branches[m.cursor].name
+	if m.pins[name] {
+		delete(m.pins, name)
+		m.status = "Unpinned " + name
+	} else {
+		m.pins[name] = true
+		m.status = "Pinned " + name
+	}
+	m.persistPins()
+}
+
+// persistPins saves the pinned branches for this repo, in list order.
+func (m *model) persistPins() {
+	var names []string
+	for _, b := range m.allBranches {
+		if m.pins[b.name] {
+			names = append(names, b.name)
+		}
+	}
+	// Keep pins for branches that aren't listed right now
+	for p := range m.pins {
+		if !slices.Contains(names, p) {
+			names = append(names, p)
+		}
+	}
+	if err := savePins(m.repoRoot, names); err != nil {
+		m.status = "Couldn't save pins: " + err.Error()
+	}
+}
+
+func nextSortOrder(current string) string {
+	for i, order := range sortOrders {
+		if order == current {
+			return sortOrders[(i+1)%len(sortOrders)]
+		}
+	}
+	return sortOrders[0]
+}
+
+// openPullRequest opens the web page for creating a pull request from the
+// highlighted branch. Remote branches use their own remote; local branches
+// use origin.
+func (m *model) openPullRequest() {
+	b := m.branches[m.cursor]
+	remote, branch := "origin", b.name
+	if b.remote {
+		var ok bool
+		if remote, branch, ok = splitRemoteRef(b.name, m.remotes); !ok {
+			m.status = "Unknown remote for " + b.name
+			return
+		}
+	}
+	remoteLink, err := remoteURL(remote)
+	if err != nil {
+		m.status = err.Error()
+		return
+	}
+	link, err := pullRequestURL(remoteLink, branch)
+	if err != nil {
+		m.status = err.Error()
+		return
+	}
+	if err := openBrowser(link); err != nil {
+		m.status = "Couldn't open browser: " + err.Error()
+		return
+	}
+	m.status = "Opened " + link
+}
+
+// renameBranch updates a renamed branch in place, carrying over its mark,
+// pin and cached preview.
+func (m *model) renameBranch(old, name string) {
+	for _, branches := range [][]branchInfo{m.branches, m.allBranches} {
+		for i := range branches {
+			if branches[i].name == old {
+				branches[i].name = name
+				branches[i].foldName = strings.ToLower(name)
+			}
+		}
+	}
+	if m.current == old {
+		m.current = name
+	}
+	if preview, ok := m.previews[old]; ok {
+		m.previews[name] = preview
+		delete(m.previews, old)
+	}
+	if m.marked[old] {
+		delete(m.marked, old)
+		m.marked[name] = true
+	}
+	if m.pins[old] {
+		delete(m.pins, old)
+		m.pins[name] = true
+		m.persistPins()
+	}
+	if m.grouped() {
+		// The new name may belong to another group
+		m.applyFilter()
+	}
+}
+
+// removeBranch drops name from both the visible and the full branch list,
+// keeping the cursor in bounds.
+func (m *model) removeBranch(name string) {
+	without := func(branches []branchInfo) []branchInfo {
+		var kept []branchInfo
+		for _, b := range branches {
+			if b.name != name {
+				kept = append(kept, b)
+			}
+		}
+		return kept
+	}
+	m.branches = without(m.branches)
+	m.allBranches = without(m.allBranches)
+	if m.cursor >= len(m.branches) {
+		m.cursor = max(0, len(m.branches)-1)
+	}
+	if m.grouped() {
+		// Drop the header of a group that is now empty
+		m.applyFilter()
+	}
+	m.clampOffset()
+}
+
+// resize recomputes the page size from the terminal height, leaving room
+// for the preview panel when it is open.
+func (m *model) resize() {
+	if !m.fixedHeight && m.height > 0 {
+		chrome := chromeLines
+		if m.compact {
+			chrome = compactChromeLines
+		} else if m.showPreview {
+			chrome += previewCommits + 2
+		}
+		if len(m.pins) > 0 {
+			chrome++ // separator below pinned branches
+		}
+		m.pageSize = max(1, m.height-chrome)
+	}
+	m.clampOffset()
+}
+
+// clampOffset scrolls the visible window so the cursor stays on screen.
+func (m *model) clampOffset() {
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+m.pageSize {
+		m.offset = m.cursor - m.pageSize + 1
+	}
+}
+
+func (m *model) applyFilter() {
+	m.filterErr = ""
+	m.matched = nil
+	if strings.TrimSpace(m.filterText) == "" {
+		// Stay on the branch that was highlighted in the filtered list
+		selected := m.highlighted()
+		m.branches = m.shownBranches()
+		if m.group {
+			m.branches = m.groupRows(m.branches)
+		}
+		m.cursor = 0
+		m.offset = 0
+		m.highlight(selected)
+		return
+	}
+
+	if m.regexMode {
+		m.applyRegexFilter()
+		return
+	}
+
+	type match struct {
+		branch    branchInfo
+		score     int
+		positions []int
+	}
+	var matches []match
+	pattern := m.filterText
+	if !m.caseSensitive {
+		pattern = strings.ToLower(pattern)
+	}
+	// Every space separated term has to match, in any order
+	terms := strings.Fields(pattern)
+	for _, branch := range m.shownBranches() {
+		name := m.foldedField(branch)
+		total := 0
+		var positions []int
+		matched := true
+		for _, term := range terms {
+			score, pos, ok := fuzzyScore(name, term)
+			if !ok {
+				matched = false
+				break
+			}
+			total += score
+			positions = append(positions, pos...)
+		}
+		if matched {
+			matches = append(matches, match{branch, total, positions})
+		}
+	}
+
+	// Equally good matches go most recent first, whatever the list's sort
+	// order, and otherwise keep their order
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].branch.committed > matches[j].branch.committed
+	})
+
+	filtered := make([]branchInfo, len(matches))
+	if !m.filterAuthor {
+		m.matched = make(map[string][]int, len(matches))
+	}
+	for i, mt := range matches {
+		filtered[i] = mt.branch
+		if m.matched != nil {
+			m.matched[mt.branch.name] = mt.positions
+		}
+	}
+	m.branches = filtered
+	m.cursor = 0
+	m.offset = 0
+}
+
+// shownBranches returns the branches the status filter lets through, which
+// the text filter then searches.
+func (m model) shownBranches() []branchInfo {
+	if m.show == showAll {
+		return m.allBranches
+	}
+	var shown []branchInfo
+	for _, b := range m.allBranches {
+		keep := b.merged == (m.show == showMerged)
+		if m.show == showGone {
+			keep = b.gone
+		}
+		if keep {
+			shown = append(shown, b)
+		}
+	}
+	return shown
+}
+
+// persistFilter saves the applied filter for the next run in this repo when
+// remembering filters is enabled.
+func (m *model) persistFilter() {
+	if !m.rememberFilter {
+		return
+	}
+	if err := saveFilter(m.repoRoot, m.filterText); err != nil {
+		m.status = "Couldn't save filter: " + err.Error()
+	}
+}
+
+// filterField returns the part of b the filter is matched against.
+func (m model) filterField(b branchInfo) string {
+	if m.filterAuthor {
+		return b.author
+	}
+	return b.name
+}
+
+// foldedField is filterField lowercased, unless matching is case-sensitive.
+func (m model) foldedField(b branchInfo) string {
+	switch {
+	case m.caseSensitive:
+		return m.filterField(b)
+	case m.filterAuthor:
+		return b.foldAuthor
+	}
+	return b.foldName
+}
+
+// applyRegexFilter keeps branches whose filter field matches filterText as a regular
+// expression. An invalid expression leaves the list unfiltered.
+func (m *model) applyRegexFilter() {
+	expr := m.filterText
+	if !m.caseSensitive {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		m.filterErr = "invalid regex"
+		m.branches = m.shownBranches()
+		m.cursor = 0
+		m.offset = 0
+		return
+	}
+
+	var filtered []branchInfo
+	if !m.filterAuthor {
+		m.matched = map[string][]int{}
+	}
+	for _, branch := range m.shownBranches() {
+		field := m.filterField(branch)
+		loc := re.FindStringIndex(field)
+		if loc == nil {
+			continue
+		}
+		filtered = append(filtered, branch)
+		if m.matched != nil {
+			start := utf8.RuneCountInString(field[:loc[0]])
+			end := start + utf8.RuneCountInString(field[loc[0]:loc[1]])
+			for p := start; p < end; p++ {
+				m.matched[branch.name] = append(m.matched[branch.name], p)
+			}
+		}
+	}
+	m.branches = filtered
+	m.cursor = 0
+	m.offset = 0
+}
+
+// fuzzyScore reports whether the runes of pattern appear in s in order and,
+// if so, how closely they match and the rune positions they matched at.
+// Consecutive runs, matches at the start of a path segment and plain
+// substring matches all score higher, and a prefix match highest of all.
+func fuzzyScore(s, pattern string) (int, []int, bool) {
+	sr := []rune(s)
+	pr := []rune(pattern)
+
+	score := 0
+	pi := 0
+	prev := -2
+	positions := make([]int, 0, len(pr))
+	for i, r := range sr {
+		if pi == len(pr) {
+			break
+		}
+		if r != pr[pi] {
+			continue
+		}
+		score++
+		if i == prev+1 {
+			score += 5
+		}
+		if i == 0 || isSeparator(sr[i-1]) {
+			score += 3
+		}
+		prev = i
+		pi++
+		positions = append(positions, i)
+	}
+	if pi < len(pr) {
+		return 0, nil, false
+	}
+
+	if strings.HasPrefix(s, pattern) {
+		score += 20
+	} else if idx := strings.Index(s, pattern); idx >= 0 {
+		score += 10
+		// Show the substring rather than wherever the runes first appeared
+		start := utf8.RuneCountInString(s[:idx])
+		for i := range positions {
+			positions[i] = start + i
+		}
+	}
+	return score, positions, true
+}
+
+func isSeparator(r rune) bool {
+	return r == '/' || r == '-' || r == '_' || r == '.'
+}
+
+func (m model) View() string {
+	if m.err != nil {
+		return fmt.Sprintf("Error: %v\n", m.err)
+	}
+
+	if m.showHelp {
+		return m.helpView()
+	}
+	if m.info != nil {
+		return m.infoView()
+	}
+
+	if len(m.branches) == 0 {
+		if !m.loaded {
+			return m.spinner.View() + " Loading branches...\n"
+		}
+		if m.fetching && m.filterText == "" {
+			return m.spinner.View() + " Fetching...\n"
+		}
+		if m.filterMode || m.liveFilter {
+			return "No branches match filter.\n\n" + m.filterPrompt()
+		}
+		// Deleting the last branch listed empties the list, so keep its
+		// status, which says how to undo
+		status := ""
+		if m.status != "" {
+			status = m.status + "\n"
+		}
+		if m.filteredApplied {
+			return status + fmt.Sprintf("No branches match filter %q.\n(esc to clear, %s to quit)\n", m.filterText, m.keys.quit.hint())
+		}
+		if m.show != showAll {
+			return status + fmt.Sprintf("No %s branches.\n(%s to show more, %s to quit)\n", m.show, m.keys.merged.hint(), m.keys.quit.hint())
+		}
+		// A new repository has no branches until the first commit, but
+		// one can still be created
+		if m.prompt == promptNewBranch {
+			return "No branches found.\n\n" + m.promptView()
+		}
+		return fmt.Sprintf("No branches found.\n(%s to create one, %s to quit)\n", m.keys.create.hint(), m.keys.quit.hint())
+	}
+
+	t := m.theme
+
+	end := m.offset + m.pageSize
+	if end > len(m.branches) {
+		end = len(m.branches)
+	}
+
+	// The blank lines around the list double as scroll indicators
+	count := fmt.Sprintf("%d branches", len(m.allBranches))
+	if len(m.allBranches) == 1 {
+		count = "1 branch"
+	}
+	if n := m.branchCount(); n != len(m.allBranches) {
+		count = fmt.Sprintf("%d of %d", n, len(m.allBranches))
+	}
+	s := ""
+	if !m.compact {
+		s = fmt.Sprintf("Select a branch to checkout (%s):\n", count)
+		if m.offset > 0 {
+			s += t.dim.Render(fmt.Sprintf("  ↑ %d more above", m.offset))
+		}
+		s += "\n"
+	}
+
+	// Pad columns to a common width so they line up
+	remoteWidth := 0
+	nameWidth := 0
+	trackWidth := 0
+	dateWidth := 0
+	for _, b := range m.branches {
+		if b.header {
+			continue
+		}
+		remote, name := m.displayName(b)
+		remoteWidth = max(remoteWidth, lipgloss.Width(remote))
+		nameWidth = max(nameWidth, lipgloss.Width(name))
+		dateWidth = max(dateWidth, lipgloss.Width(b.lastCommit))
+		if !m.compact {
+			trackWidth = max(trackWidth, lipgloss.Width(trackLabel(b)))
+		}
+	}
+
+	// Shrink the name column rather than letting rows wrap
+	if m.width > 0 {
+		avail := m.width - 2
+		if len(m.marked) > 0 {
+			avail -= lipgloss.Width(m.theme.checkMark) + 1
+		}
+		if remoteWidth > 0 {
+			avail -= remoteWidth + 2
+		}
+		if trackWidth > 0 {
+			avail -= trackWidth + 2
+		}
+		if dateWidth > 0 {
+			avail -= dateWidth + 2
+		}
+		nameWidth = max(1, min(nameWidth, avail))
+	}
+
+	sepWidth := nameWidth
+	if len(m.marked) > 0 {
+		sepWidth += lipgloss.Width(t.checkMark) + 1
+	}
+	if remoteWidth > 0 {
+		sepWidth += remoteWidth + 2
+	}
+	var formatWidths map[string]int
+	formatWidth := 0
+	if m.format != nil {
+		if m.width > 0 {
+			formatWidth = m.width - 2
+			if len(m.marked) > 0 {
+				formatWidth -= lipgloss.Width(t.checkMark) + 1
+			}
+		}
+		indent := 0
+		if m.grouped() {
+			indent = 2
+		}
+		formatWidths = m.format.widths(m.branches, indent, formatWidth)
+	}
+
+	sep := m.pinSeparator()
+	for i := m.offset; i < end; i++ {
+		if i == sep && i > m.offset {
+			s += t.dim.Render("  "+strings.Repeat("─", sepWidth)) + "\n"
+		}
+		b := m.branches[i]
+		if b.header {
+			s += m.finishRow(m.headerView(b, m.cursor == i), m.cursor == i)
+			continue
+		}
+		remote, name := m.displayName(b)
+		isCurrent := m.current != "" && b.name == m.current
+		cursor := " "
+		style := lipgloss.NewStyle()
+		if m.cursor == i {
+			cursor = t.cursor.Render(t.cursorMark)
+			if isCurrent {
+				style = t.currentSelected
+			} else {
+				style = t.selected
+			}
+		} else if isCurrent {
+			cursor = t.current.Render("*")
+			style = t.current
+		} else if _, ok := m.worktrees[b.name]; ok {
+			cursor = t.dim.Render("+")
+			style = t.dim
+		} else if m.query.all && b.remote {
+			style = t.remote
+		}
+		branch := m.renderName(b, name, nameWidth, style)
+		line := cursor + " "
+		if len(m.marked) > 0 {
+			if m.marked[b.name] {
+				line += t.cursor.Render(t.checkMark) + " "
+			} else {
+				line += strings.Repeat(" ", lipgloss.Width(t.checkMark)+1)
+			}
+		}
+		if m.format != nil {
+			name := b.name
+			if m.grouped() && m.groupOf(b) != "" {
+				name = "  " + name
+			}
+			s += m.finishRow(line+m.formatRow(b, name, formatWidths, formatWidth, style), m.cursor == i)
+			continue
+		}
+		if remoteWidth > 0 {
+			line += t.dim.Render(remote) + strings.Repeat(" ", remoteWidth-lipgloss.Width(remote)) + "  "
+		}
+		line += branch
+		if trackWidth > 0 {
+			track := trackLabel(b)
+			line += "  " + t.dim.Render(track) + strings.Repeat(" ", trackWidth-lipgloss.Width(track))
+		}
+		if dateWidth > 0 {
+			line += "  " + m.ageStyle(b).Render(b.lastCommit) + strings.Repeat(" ", dateWidth-lipgloss.Width(b.lastCommit))
+		}
+		if m.showSubjects && !m.compact && b.subject != "" {
+			subject := b.subject
+			if m.width > 0 {
+				// Whatever room is left after the other columns
+				room := m.width - lipgloss.Width(line) - 2
+				if room < 5 {
+					subject = ""
+				} else {
+					subject = truncate(subject, room)
+				}
+			}
+			if subject != "" {
+				line += "  " + t.dim.Render(subject)
+			}
+		}
+		s += m.finishRow(line, m.cursor == i)
+	}
+
+	if !m.compact {
+		if end < len(m.branches) {
+			s += t.dim.Render(fmt.Sprintf("  ↓ %d more below", len(m.branches)-end))
+		}
+		s += "\n"
+	}
+
+	if m.showPreview && !m.compact && !m.onHeader() {
+		name := m.branches[m.cursor].name
+		s += t.dim.Render(fmt.Sprintf("Recent commits on %s:", name)) + "\n"
+		for _, c := range m.previews[name] {
+			if m.width > 0 {
+				c = truncate(c, m.width-2)
+			}
+			s += "  " + c + "\n"
+		}
+		s += "\n"
+	}
+
+	// The position shares a line with any status message; the header
+	// has the counts
+	info := fmt.Sprintf("%d/%d", m.position(), m.branchCount())
+	if m.show != showAll {
+		info += fmt.Sprintf(" [%s]", m.show)
+	}
+	s += t.dim.Render(info)
+	if m.fetching {
+		s += "  " + m.spinner.View() + " Fetching..."
+	} else if m.loading {
+		s += "  " + m.spinner.View() + " Loading..."
+	}
+	if m.status != "" {
+		s += "  " + m.status
+	}
+	s += "\n"
+
+	if m.prompt != promptNone {
+		return s + m.promptView()
+	}
+
+	switch m.confirm {
+	case confirmDetached:
+		s += "HEAD is detached at commits that aren't on any branch or tag; checking out will leave them behind.\n"
+		s += "(y to checkout anyway, n to cancel)\n"
+		return s
+	case confirmDirty:
+		s += "You have uncommitted changes. Checkout anyway?\n"
+		if warning := m.unpushedWarning(m.chosen); warning != "" {
+			s += m.theme.dim.Render("Note: "+warning) + "\n"
+		}
+		if m.stashPop {
+			s += "(y to checkout, s to stash, checkout and reapply them, n to cancel)\n"
+		} else {
+			s += "(y to checkout, s to stash and checkout, n to cancel)\n"
+		}
+		return s
+	case confirmDelete:
+		b := m.deleting
+		if b.remote {
+			s += fmt.Sprintf("Delete remote-tracking branch %s?\n", b.name)
+			s += "(y to delete the local ref, r to also delete it on the remote, n to cancel)\n"
+			return s
+		}
+		s += fmt.Sprintf("Delete branch %s?\n", b.name)
+		s += "(y to delete, n to cancel)\n"
+		return s
+	case confirmPushDelete:
+		s += fmt.Sprintf("This deletes %s on the remote for everyone. Are you sure?\n", m.deleting.name)
+		s += "(y to delete on the remote, n to cancel)\n"
+		return s
+	case confirmDeleteMarked:
+		names := m.markedNames()
+		s += fmt.Sprintf("Delete %d marked branches? %s\n", len(names), strings.Join(names, ", "))
+		s += "(y to delete, n to cancel)\n"
+		return s
+	case confirmForceDelete:
+		s += m.deleteErr + "\n"
+		s += "Force delete with -D? (y to delete, n to cancel)\n"
+		return s
+	}
+
+	k := m.keys
+	selectHelp := k.choose.hint() + " to select"
+	if m.query.remote || m.query.all {
+		selectHelp = fmt.Sprintf("%s to check out, %s to detach", k.choose.hint(), k.detach.hint())
+	}
+	if m.filterMode || m.liveFilter {
+		s += m.filterPrompt()
+	} else if m.compact {
+		return s
+	} else if m.filteredApplied {
+		if m.filterAuthor {
+			s += fmt.Sprintf("[Filtered by author: %s] ", m.filterText)
+		} else {
+			s += fmt.Sprintf("[Filtered: %s] ", m.filterText)
+		}
+		s += fmt.Sprintf("(%s to filter, esc to clear, %s/%s to move, %s, %s to quit, %s for help)\n", k.filter.hint(), k.down.hint(), k.up.hint(), selectHelp, k.quit.hint(), k.help.hint())
+	} else {
+		s += fmt.Sprintf("(%s to filter, %s/%s to move, %s, %s to quit, %s for help)\n", k.filter.hint(), k.down.hint(), k.up.hint(), selectHelp, k.quit.hint(), k.help.hint())
+	}
+
+	return s
+}
+
+// ansiStyle matches the escape sequences lipgloss styles text with.
+var ansiStyle = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// finishRow trims the padding off a rendered row. With highlight_row the
+// highlighted row is instead redrawn in the theme's row style, across the
+// whole terminal when its width is known; its own colors are dropped so
+// they don't cut the background short.
+func (m model) finishRow(line string, selected bool) string {
+	if !m.highlightRow || !selected {
+		return strings.TrimRight(line, " ") + "\n"
+	}
+	plain := strings.TrimRight(ansiStyle.ReplaceAllString(line, ""), " ")
+	style := m.theme.row
+	if m.width > 0 {
+		style = style.Copy().Width(m.width)
+	}
+	return style.Render(plain) + "\n"
+}
+
+// promptView renders the text prompt being typed into.
+func (m model) promptView() string {
+	var s, help string
+	switch m.prompt {
+	case promptNewBranch:
+		s = "New branch: " + withCaret(m.promptText, m.promptCaret)
+		help = "(enter to create and checkout, esc to cancel)"
+	case promptRename:
+		s = fmt.Sprintf("Rename %s to: %s", m.branches[m.cursor].name, withCaret(m.promptText, m.promptCaret))
+		help = "(enter to rename, esc to cancel)"
+	}
+	if m.promptErr != "" {
+		s += "  " + m.promptErr
+	}
+	return s + "\n" + help + "\n"
+}
+
+// filterPrompt renders the filter input line and its help text.
+func (m model) filterPrompt() string {
+	var modes []string
+	if m.regexMode {
+		modes = append(modes, "regex")
+	}
+	if m.caseSensitive {
+		modes = append(modes, "case-sensitive")
+	}
+	label := "Filter"
+	if m.filterAuthor {
+		label += " by author"
+	}
+	if len(modes) > 0 {
+		label += " (" + strings.Join(modes, ", ") + ")"
+	}
+	s := fmt.Sprintf("%s: /%s", label, withCaret(m.filterText, m.filterCaret))
+	if m.filterErr != "" {
+		s += "  " + m.filterErr
+	}
+	if m.liveFilter {
+		s += "\n(type to filter, ↑/↓ to move, enter to select, esc to clear or quit)\n"
+	} else {
+		s += "\n(type to filter, ctrl+r to toggle regex, ctrl+s to toggle case, ctrl+t to match authors, enter to keep, esc to cancel)\n"
+	}
+	return s
+}
+
+// hasUncommittedChanges reports whether the working tree has staged,
+// unstaged or untracked changes.
+func hasUncommittedChanges() (bool, error) {
+	output, err := git.Output("status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	return len(strings.TrimSpace(string(output))) > 0, nil
+}
+
+// stashChanges stashes local changes, reporting whether there were any
+// that git could stash; untracked files, for one, are left alone.
+func stashChanges() (bool, error) {
+	before, _ := git.Output("rev-parse", "-q", "--verify", "refs/stash")
+	if err := runGit("stash"); err != nil {
+		return false, err
+	}
+	after, _ := git.Output("rev-parse", "-q", "--verify", "refs/stash")
+	return string(after) != string(before), nil
+}
+
+// popStash reapplies the most recent stash. git keeps the stash if the pop
+// fails, and the error carries its description of the conflicts.
+func popStash() error {
+	output, err := git.Output("stash", "pop")
+	if err == nil {
+		return nil
+	}
+	// The conflicts are reported on stdout, not stderr
+	var exitErr *exec.ExitError
+	if report := strings.TrimSpace(string(output)); report != "" {
+		if errors.As(err, &exitErr) {
+			return errors.New(report)
+		}
+		return fmt.Errorf("%v\n%s", err, report)
+	}
+	return err
+}
+
+// renderName renders the name column for b, where name is the part of its
+// name shown in the column. The name is cut to width and padded to it, and
+// the runes the filter matched are highlighted.
+func (m model) renderName(b branchInfo, name string, width int, style lipgloss.Style) string {
+	shown := truncate(name, width)
+	positions := m.matched[b.name]
+	if len(positions) == 0 {
+		return style.Render(shown + strings.Repeat(" ", width-lipgloss.Width(shown)))
+	}
+	pad := ""
+	if n := width - lipgloss.Width(shown); n > 0 {
+		pad = style.Render(strings.Repeat(" ", n))
+	}
+
+	// Positions count from the start of the full name, which may begin
+	// with a remote shown in its own column
+	shift := utf8.RuneCountInString(b.name) - utf8.RuneCountInString(name)
+	runes := []rune(shown)
+	visible := len(runes)
+	if shown != name {
+		visible-- // the ellipsis
+	}
+	hit := make([]bool, len(runes))
+	for _, p := range positions {
+		if p -= shift; p >= 0 && p < visible {
+			hit[p] = true
+		}
+	}
+
+	// Style runs of matched and unmatched runes rather than each rune
+	match := m.theme.match.Copy().Inherit(style)
+	var out strings.Builder
+	for start := 0; start < len(runes); {
+		end := start + 1
+		for end < len(runes) && hit[end] == hit[start] {
+			end++
+		}
+		if hit[start] {
+			out.WriteString(match.Render(string(runes[start:end])))
+		} else {
+			out.WriteString(style.Render(string(runes[start:end])))
+		}
+		start = end
+	}
+	return out.String() + pad
+}
+
+// truncate shortens s to at most width cells, marking the cut with an
+// ellipsis.
+func truncate(s string, width int) string {
+	if lipgloss.Width(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && lipgloss.Width(string(runes))+1 > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// recentCommits returns the one-line summaries of the last n commits on
+// branch.
+func recentCommits(branch string, n int) ([]string, error) {
+	output, err := git.Output("log", "--oneline", "-n", fmt.Sprint(n), branch, "--")
+	if err != nil {
+		return nil, err
+	}
+	trimmed := strings.TrimSpace(string(output))
+	if trimmed == "" {
+		return nil, nil
+	}
+	return strings.Split(trimmed, "\n"), nil
+}
+
+// runGit runs a git command for its side effects. On failure the returned
+// error carries git's own message.
+func runGit(args ...string) error {
+	_, err := git.Output(args...)
+	return err
+}
+
+// fetchAll fetches every remote, pruning deleted remote branches.
+func fetchAll() error {
+	return runGit("fetch", "--all", "--prune")
+}
+
+// createBranch creates name from HEAD and checks it out.
+func createBranch(name string) error {
+	return runGit("checkout", "-b", name)
+}
+
+func renameBranch(old, name string) error {
+	return runGit("branch", "-m", old, name)
+}
+
+// previousBranch returns the branch checked out before the current one,
+// the one git checkout - switches to.
+func previousBranch() (string, error) {
+	output, err := git.Output("rev-parse", "--symbolic-full-name", "@{-1}")
+	name, ok := strings.CutPrefix(strings.TrimSpace(string(output)), "refs/heads/")
+	if err != nil || !ok || !localBranchExists(name) {
+		return "", errors.New("no previous branch to switch back to")
+	}
+	return name, nil
+}
+
+// localBranchExists reports whether refs/heads/name exists, whether or not
+// it is in the loaded list.
+func localBranchExists(name string) bool {
+	_, err := git.Output("show-ref", "--verify", "--quiet", "refs/heads/"+name)
+	return err == nil
+}
+
+// deleteBranch runs git branch -d (or -D when force is set).
+func deleteBranch(name string, force bool) error {
+	flag := "-d"
+	if force {
+		flag = "-D"
+	}
+	return runGit("branch", flag, name)
+}
+
+// deleteRemoteTracking removes the local remote-tracking ref, leaving the
+// branch on the remote alone.
+func deleteRemoteTracking(ref string) error {
+	return runGit("branch", "-d", "-r", ref)
+}
+
+// deleteOnRemote deletes branch from the remote itself, which also removes
+// the remote-tracking ref.
+func deleteOnRemote(remote, branch string) error {
+	return runGit("push", remote, "--delete", branch)
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// listRemotes returns the names of the configured remotes.
+func listRemotes() ([]string, error) {
+	output, err := git.Output("remote")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// splitRemoteRef splits a remote-tracking ref such as "origin/feature/foo"
+// into its remote and branch parts. The remote is matched against the
+// configured remotes, preferring the longest, so remote names that contain
+// slashes are handled. ok is false if no remote matches.
+func splitRemoteRef(ref string, remotes []string) (remote, branch string, ok bool) {
+	for _, r := range remotes {
+		if strings.HasPrefix(ref, r+"/") && len(r) > len(remote) {
+			remote = r
+		}
+	}
+	if remote == "" {
+		return "", "", false
+	}
+	return remote, strings.TrimPrefix(ref, remote+"/"), true
+}
+
+// checkoutArgs returns the git arguments that check out branch. A remote
+// branch is checked out through its local branch, which is created to
+// track the remote if it doesn't exist yet, unless detach asks for a
+// detached HEAD.
+func checkoutArgs(branch string, remote, detach bool) []string {
+	if detach {
+		return []string{"checkout", "--detach", branch}
+	}
+	if remote {
+		remotes, _ := listRemotes()
+		local := localBranchFor(branch, remotes)
+		if localBranchExists(local) {
+			return []string{"checkout", local}
+		}
+		return []string{"checkout", "-b", local, "--track", branch}
+	}
+	return []string{"checkout", branch}
+}
+
+// remoteOf returns the remote that the remote-tracking ref belongs to, or
+// an empty string if it isn't one of remotes. Remote names can contain
+// slashes, so the longest match wins.
+func remoteOf(ref string, remotes map[string]bool) string {
+	for i := len(ref) - 1; i > 0; i-- {
+		if ref[i] == '/' && remotes[ref[:i]] {
+			return ref[:i]
+		}
+	}
+	return ""
+}
+
+// remoteBranch returns the name of a remote-tracking branch without its
+// remote, e.g. "feature/x" for "origin/feature/x". ok is false for local
+// branches and refs of unknown remotes.
+func (b branchInfo) remoteBranch() (name string, ok bool) {
+	if !b.remote || b.remoteName == "" {
+		return "", false
+	}
+	return strings.TrimPrefix(b.name, b.remoteName+"/"), true
+}
+
+// localBranchFor returns the local branch name that checks out the
+// remote-tracking ref, e.g. "feature/x" for "origin/feature/x". Without a
+// matching remote everything up to the first slash is taken to be the
+// remote, as git does; a ref without one is returned unchanged.
+func localBranchFor(remoteRef string, remotes []string) string {
+	if _, branch, ok := splitRemoteRef(remoteRef, remotes); ok {
+		return branch
+	}
+	if _, branch, ok := strings.Cut(remoteRef, "/"); ok && branch != "" {
+		return branch
+	}
+	return remoteRef
+}
+
+// jsonBranch is how a branch is written by --json.
+type jsonBranch struct {
+	Name          string `json:"name"`
+	CommitterDate string `json:"committerdate"`
+	Author        string `json:"author"`
+	Subject       string `json:"subject"`
+	Ahead         int    `json:"ahead"`
+	Behind        int    `json:"behind"`
+	Remote        bool   `json:"remote"`
+	Merged        bool   `json:"merged"`
+	Gone          bool   `json:"gone"`
+	Tag           bool   `json:"tag"`
+	Current       bool   `json:"current"`
+}
+
+// printJSON writes branches to w as a JSON array.
+func printJSON(w io.Writer, branches []branchInfo, current string) error {
+	out := make([]jsonBranch, len(branches))
+	for i, b := range branches {
+		out[i] = jsonBranch{
+			Name:          b.name,
+			Tag:           b.tag,
+			CommitterDate: b.lastCommit,
+			Author:        b.author,
+			Subject:       b.subject,
+			Ahead:         b.ahead,
+			Behind:        b.behind,
+			Remote:        b.remote,
+			Merged:        b.merged,
+			Gone:          b.gone,
+			Current:       current != "" && b.name == current,
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// Exit codes, so scripts can tell a cancelled picker from a failure.
+const (
+	exitOK        = 0
+	exitError     = 1
+	exitCancelled = 2
+)
+
+func main() {
+	// The man page documents the built-in defaults, so it ignores the
+	// config file and environment of whoever generates it
+	manPage := wantsManPage(os.Args[1:])
+	cfg, getenv := loadConfig(), os.Getenv
+	logPath := debugLogPath()
+	if manPage {
+		cfg, getenv = defaultConfig(), func(string) string { return "" }
+		logPath = "$TMPDIR/git-recent-debug.log"
+	}
+
+	flag.StringVar(&workDir, "C", "", "run in the repository at `path` instead of the current directory")
+	flag.BoolVar(&cfg.remote, "r", cfg.remote, "list remote branches")
+	flag.BoolVar(&cfg.remote, "remote", cfg.remote, "list remote branches")
+	flag.DurationVar(&cfg.timeout, "timeout", cfg.timeout, "give up on git commands that take longer than `duration`, except those that change the repository; 0 for no limit")
+	flag.StringVar(&cfg.remoteName, "remote-name", cfg.remoteName, "only list branches of the remote called `name` (implies -r)")
+	flag.BoolVar(&cfg.all, "a", cfg.all, "list local and remote branches together")
+	flag.BoolVar(&cfg.all, "all", cfg.all, "list local and remote branches together")
+	flag.BoolVar(&cfg.fetch, "fetch", cfg.fetch, "fetch all remotes before listing")
+	flag.IntVar(&cfg.height, "height", cfg.height, "number of branches to show at once (default: fit the terminal)")
+	flag.IntVar(&cfg.limit, "limit", cfg.limit, "maximum number of branches to load, 0 for no limit")
+	flag.BoolVar(&cfg.tags, "tags", cfg.tags, "list tags alongside the branches")
+	flag.BoolVar(&cfg.group, "group", cfg.group, "list branches under a collapsible header per prefix, e.g. feature/")
+	flag.BoolVar(&cfg.mine, "mine", cfg.mine, "only list branches whose latest commit is yours (by user.email)")
+	flag.Var(&stringList{values: &cfg.exclude}, "exclude", "hide branches matching a glob pattern (repeatable)")
+	flag.StringVar(&cfg.base, "base", cfg.base, "`branch` that merged badges and filters compare against (default: detected from origin/HEAD, init.defaultBranch, main or master)")
+	flag.StringVar(&cfg.sort, "sort", cfg.sort, "sort order: "+strings.Join(sortOrders, ", "))
+	flag.StringVar(&cfg.dateFormat, "date-format", cfg.dateFormat, "how to show commit dates: "+strings.Join(dateFormatNames, ", "))
+	back := flag.Bool("back", false, "check out the previous branch, like git checkout -, and exit")
+	printMode := flag.Bool("p", false, "print the most recent branch and exit")
+	flag.BoolVar(printMode, "print", false, "print the most recent branch and exit")
+	jsonMode := flag.Bool("json", false, "print the branch list as JSON and exit")
+	flag.StringVar(&cfg.theme, "theme", cfg.theme, "color theme: "+strings.Join(themeNames(), ", "))
+	flag.BoolVar(&cfg.highlightRow, "highlight-row", cfg.highlightRow, "give the highlighted row a background across the terminal")
+	flag.BoolVar(&cfg.rememberFilter, "remember-filter", cfg.rememberFilter, "restore the filter last applied in this repo")
+	flag.BoolVar(&cfg.liveFilter, "live-filter", cfg.liveFilter, "filter as you type, without pressing / first")
+	flag.BoolVar(&cfg.stashPop, "stash-pop", cfg.stashPop, "reapply changes stashed from the picker after checking out")
+	flag.BoolVar(&cfg.inline, "inline", cfg.inline, "draw the picker below the prompt instead of on the alternate screen")
+	flag.StringVar(&cfg.format, "format", cfg.format, "what each row shows, e.g. \"{name}  {date}  {author}\", using {"+strings.Join(formatFields, "}, {")+"}")
+	flag.BoolVar(&cfg.compact, "compact", cfg.compact, "only show branch names and dates, without the header and help lines")
+	flag.BoolVar(&cfg.selectOnly, "select-only", false, "print the selected branch instead of checking it out")
+	flag.BoolVar(&cfg.dryRun, "dry-run", false, "print the checkout that would happen instead of running it")
+	flag.BoolVar(&cfg.worktree, "worktree", false, "add a worktree for the selected branch instead of checking it out")
+	if path := getenv("GIT_RECENT_GIT"); path != "" {
+		gitPath = path
+	}
+	flag.StringVar(&gitPath, "git-path", gitPath, "git executable to run (or set GIT_RECENT_GIT)")
+	debug := flag.Bool("debug", getenv("GIT_RECENT_DEBUG") != "", "log every git command to "+logPath+" (or set GIT_RECENT_DEBUG)")
+	showVersion := flag.Bool("v", false, "print the version and exit")
+	flag.BoolVar(showVersion, "version", false, "print the version and exit")
+	genMan := flag.Bool("gen-man", false, "write a man page to stdout and exit")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintln(out, "Usage: git-recent [flags]")
+		fmt.Fprintln(out)
+		printUsageFlags(out)
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "Exit status:")
+		fmt.Fprintf(out, "  %d  a branch was checked out, created or selected\n", exitOK)
+		fmt.Fprintf(out, "  %d  an error occurred\n", exitError)
+		fmt.Fprintf(out, "  %d  quit without choosing a branch\n", exitCancelled)
+	}
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("git-recent %s\n", version)
+		return
+	}
+
+	if *genMan {
+		writeManPage(os.Stdout)
+		return
+	}
+
+	// The log package writes to stderr by default, which would draw over
+	// the picker, so it is only ever pointed at the debug file
+	log.SetOutput(io.Discard)
+	if *debug {
+		f, err := tea.LogToFile(debugLogPath(), "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		defer f.Close()
+		log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+		log.Printf("git-recent %s %s", version, strings.Join(os.Args[1:], " "))
+	}
+
+	gitTimeout = cfg.timeout
+
+	if _, ok := sortKeys[cfg.sort]; !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown sort order %q (want one of %s)\n", cfg.sort, strings.Join(sortOrders, ", "))
+		os.Exit(exitError)
+	}
+
+	if _, ok := dateFormats[cfg.dateFormat]; !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown date format %q (want one of %s)\n", cfg.dateFormat, strings.Join(dateFormatNames, ", "))
+		os.Exit(exitError)
+	}
+
+	if _, err := parseRowFormat(cfg.format); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	if _, ok := themes()[cfg.theme]; !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown theme %q (want one of %s)\n", cfg.theme, strings.Join(themeNames(), ", "))
+		os.Exit(exitError)
+	}
+
+	if err := findGit(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	if workDir != "" {
+		if info, err := os.Stat(workDir); err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "Error: %s is not a directory\n", workDir)
+			os.Exit(exitError)
+		}
+	}
+
+	inside, err := isInsideWorkTree()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if !inside {
+		if workDir != "" {
+			fmt.Fprintf(os.Stderr, "Error: %s is not a git repository\n", workDir)
+		} else {
+			fmt.Fprintln(os.Stderr, "Error: not a git repository")
+		}
+		os.Exit(exitError)
+	}
+
+	if cfg.mine {
+		output, err := git.Output("config", "user.email")
+		cfg.email = strings.TrimSpace(string(output))
+		if err != nil || cfg.email == "" {
+			fmt.Fprintln(os.Stderr, "Error: --mine needs user.email to be set in git config")
+			os.Exit(exitError)
+		}
+	}
+
+	// Resolved once here so every feature compares against the same base
+	if cfg.base == "" {
+		cfg.base = defaultBranch()
+	} else if !branchExists(cfg.base) {
+		fmt.Fprintf(os.Stderr, "Error: base branch %q not found\n", cfg.base)
+		os.Exit(exitError)
+	}
+
+	if cfg.remoteName != "" {
+		remotes, err := listRemotes()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		if !slices.Contains(remotes, cfg.remoteName) {
+			fmt.Fprintf(os.Stderr, "Error: no remote named %q (have %s)\n", cfg.remoteName, strings.Join(remotes, ", "))
+			os.Exit(exitError)
+		}
+		if !cfg.all {
+			cfg.remote = true
+		}
+	}
+
+	if *jsonMode {
+		branches, err := getRecentBranches(cfg.query())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		current, _ := currentBranch()
+		if err := printJSON(os.Stdout, branches, current); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		return
+	}
+
+	if *back {
+		name, err := previousBranch()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		switch {
+		case cfg.selectOnly:
+			fmt.Println(name)
+		case cfg.dryRun:
+			fmt.Printf("Would checkout: %s (git checkout %s)\n", name, name)
+		default:
+			if err := runGit("checkout", name); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to checkout branch: %v\n", err)
+				os.Exit(exitError)
+			}
+			fmt.Printf("Checked out: %s\n", name)
+		}
+		return
+	}
+
+	if *printMode {
+		if cfg.fetch {
+			if err := fetchAll(); err != nil {
+				fmt.Fprintf(os.Stderr, "Fetch failed: %v\n", firstLine(err.Error()))
+			}
+		}
+		branches, err := getRecentBranches(cfg.query())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		if len(branches) == 0 {
+			os.Exit(exitError)
+		}
+		fmt.Println(branches[0].name)
+		return
+	}
+
+	// Without a terminal to draw the picker on, as in git-recent | head,
+	// list the branches instead. --select-only draws on stderr, so its
+	// stdout is expected to be captured
+	if !term.IsTerminal(int(os.Stdin.Fd())) || (!cfg.selectOnly && !term.IsTerminal(int(os.Stdout.Fd()))) {
+		if cfg.fetch {
+			if err := fetchAll(); err != nil {
+				fmt.Fprintf(os.Stderr, "Fetch failed: %v\n", firstLine(err.Error()))
+			}
+		}
+		branches, err := getRecentBranches(cfg.query())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		for _, b := range branches {
+			fmt.Println(b.name)
+		}
+		return
+	}
+
+	var opts []tea.ProgramOption
+	if !cfg.inline {
+		// The alternate screen leaves the terminal as it was once the
+		// picker exits, instead of the list lingering in the scrollback.
+		// Mouse rows are screen rows, which only match the rows of the
+		// view when it starts at the top of the alternate screen.
+		opts = append(opts, tea.WithAltScreen(), tea.WithMouseCellMotion())
+	}
+	if cfg.selectOnly {
+		// Keep stdout clean for the selected branch name, and pick colors
+		// for the terminal on stderr rather than for the pipe on stdout
+		opts = append(opts, tea.WithOutput(os.Stderr))
+		lipgloss.SetDefaultRenderer(lipgloss.NewRenderer(os.Stderr))
+	}
+	p := tea.NewProgram(initialModel(cfg), opts...)
+	m, err := p.Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	finalModel := m.(model)
+	if finalModel.err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", finalModel.err)
+		os.Exit(exitError)
+	}
+	// A live filter is never kept with enter, so it is saved as it was
+	// when the picker exited, however that happened
+	if finalModel.liveFilter && finalModel.rememberFilter {
+		if err := saveFilter(finalModel.repoRoot, finalModel.filterText); err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't save filter: %v\n", err)
+		}
+	}
+
+	if finalModel.created != "" {
+		if cfg.dryRun {
+			fmt.Printf("Would create and checkout: %s (git checkout -b %s)\n", finalModel.created, finalModel.created)
+		} else if cfg.selectOnly {
+			fmt.Println(finalModel.created)
+		} else {
+			fmt.Printf("Created and checked out: %s\n", finalModel.created)
+		}
+		return
+	}
+
+	if finalModel.selected {
+		selected := finalModel.chosen
+		selectedBranch := selected.name
+		if cfg.selectOnly {
+			fmt.Println(selectedBranch)
+			return
+		}
+		if cfg.dryRun && finalModel.worktreeDir != "" {
+			args := worktreeArgs(selected, finalModel.worktreeBranch(selected), finalModel.worktreeDir)
+			fmt.Printf("Would add a worktree for %s at %s (git %s)\n", selectedBranch, finalModel.worktreeDir, strings.Join(args, " "))
+			return
+		}
+		// A branch that already has a worktree only gets its path printed
+		if cfg.dryRun && len(finalModel.checkoutLog) == 0 {
+			if finalModel.stash {
+				fmt.Println("Would stash local changes")
+			}
+			args := checkoutArgs(selectedBranch, selected.remote, finalModel.detach || selected.tag)
+			fmt.Printf("Would checkout: %s (git %s)\n", selectedBranch, strings.Join(args, " "))
+			return
+		}
+		for _, line := range finalModel.checkoutLog {
+			fmt.Println(line)
+		}
+		if finalModel.popErr != nil {
+			fmt.Fprintln(os.Stderr, finalModel.popErr)
+			fmt.Fprintln(os.Stderr, "Failed to reapply stashed changes; the stash was kept. Resolve the conflicts, then run git stash drop")
+			os.Exit(exitError)
+		}
+		return
+	}
+
+	os.Exit(exitCancelled)
 }
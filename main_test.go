@@ -0,0 +1,537 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// testBranches are listed most recent first, as git would list them.
+var testBranches = []branchInfo{
+	{name: "main", author: "Ada", committed: 400},
+	{name: "feature/Billing", author: "Bob", committed: 300},
+	{name: "bugfix/login", author: "Ada", committed: 200},
+	{name: "release/1.0", author: "Cy", committed: 100},
+}
+
+// testModel returns a picker that has loaded branches, with git replaced
+// by a fake that knows nothing about any repository.
+func testModel(t *testing.T, branches ...branchInfo) model {
+	t.Helper()
+	useGit(t, &fakeGit{})
+	next, _ := initialModel(defaultConfig()).Update(branchesLoadedMsg{branches: slices.Clone(branches)})
+	return next.(model)
+}
+
+func names(branches []branchInfo) []string {
+	var names []string
+	for _, b := range branches {
+		names = append(names, b.name)
+	}
+	return names
+}
+
+func TestApplyFilter(t *testing.T) {
+	all := names(testBranches)
+	tests := []struct {
+		name       string
+		filter     string
+		want       []string
+		wantCursor int // clearing the filter stays on the highlighted branch
+	}{
+		{name: "empty filter restores the full list", filter: "", want: all, wantCursor: 3},
+		{name: "blank filter restores the full list", filter: "  ", want: all, wantCursor: 3},
+		{name: "case-insensitive query", filter: "BILL", want: []string{"feature/Billing"}},
+		{name: "lowercase query matches uppercase name", filter: "billing", want: []string{"feature/Billing"}},
+		{name: "every term has to match", filter: "fix log", want: []string{"bugfix/login"}},
+		{name: "no match", filter: "zzz", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testModel(t, testBranches...)
+			// Start on the last branch, scrolled down a line
+			m.cursor, m.offset = 3, 1
+
+			m.filterText = tt.filter
+			m.applyFilter()
+			if got := names(m.branches); !slices.Equal(got, tt.want) {
+				t.Errorf("branches = %q, want %q", got, tt.want)
+			}
+			if m.cursor != tt.wantCursor || m.offset != 0 {
+				t.Errorf("cursor, offset = %d, %d, want %d, 0", m.cursor, m.offset, tt.wantCursor)
+			}
+			if got := names(m.allBranches); !slices.Equal(got, all) {
+				t.Errorf("allBranches = %q, want %q", got, all)
+			}
+		})
+	}
+}
+
+func TestSplitRemoteRef(t *testing.T) {
+	remotes := []string{"origin", "upstream", "corp/mirror", "corp"}
+	tests := []struct {
+		ref            string
+		remote, branch string
+		ok             bool
+	}{
+		{"origin/main", "origin", "main", true},
+		{"origin/feature/foo", "origin", "feature/foo", true},
+		{"upstream/origin/foo", "upstream", "origin/foo", true},
+		{"upstream/release/1.0", "upstream", "release/1.0", true},
+		{"corp/mirror/feature/x", "corp/mirror", "feature/x", true},
+		{"corp/other/x", "corp", "other/x", true},
+		{"fork/main", "", "", false},
+		{"main", "", "", false},
+		{"origin", "", "", false},
+	}
+	for _, tt := range tests {
+		remote, branch, ok := splitRemoteRef(tt.ref, remotes)
+		if remote != tt.remote || branch != tt.branch || ok != tt.ok {
+			t.Errorf("splitRemoteRef(%q) = %q, %q, %v, want %q, %q, %v", tt.ref, remote, branch, ok, tt.remote, tt.branch, tt.ok)
+		}
+	}
+}
+
+func TestLocalBranchFor(t *testing.T) {
+	remotes := []string{"origin", "upstream"}
+	tests := []struct {
+		ref, want string
+	}{
+		{"origin/main", "main"},
+		{"origin/feature/x", "feature/x"},
+		{"upstream/release/1.0", "release/1.0"},
+		// A remote that isn't configured only loses its first component
+		{"fork/feature/x", "feature/x"},
+		{"main", "main"},
+	}
+	for _, tt := range tests {
+		if got := localBranchFor(tt.ref, remotes); got != tt.want {
+			t.Errorf("localBranchFor(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+// keyMsg builds the message for a key spelled the way tea.KeyMsg.String()
+// spells it, such as "j", "enter" or "alt+enter".
+func keyMsg(key string) tea.KeyMsg {
+	key, alt := strings.CutPrefix(key, "alt+")
+	for t := tea.KeyType(-200); t < 200; t++ {
+		if t != tea.KeyRunes && (tea.Key{Type: t}).String() == key {
+			return tea.KeyMsg{Type: t, Alt: alt}
+		}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key), Alt: alt}
+}
+
+// press sends keys to m one at a time, as if they were typed.
+func press(m model, keys ...string) model {
+	for _, k := range keys {
+		next, _ := m.Update(keyMsg(k))
+		m = next.(model)
+	}
+	return m
+}
+
+func TestViewNoColor(t *testing.T) {
+	// Render as if to a true-color terminal (termenv.TrueColor), so any
+	// styling that NO_COLOR doesn't turn off shows up
+	saved := lipgloss.ColorProfile()
+	lipgloss.SetColorProfile(0)
+	t.Cleanup(func() { lipgloss.SetColorProfile(saved) })
+
+	branches := slices.Clone(testBranches)
+	branches[1].ahead, branches[1].merged = 2, true
+	branches[2].gone = true
+	load := func() model {
+		m := testModel(t, branches...)
+		next, _ := m.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+		m = next.(model)
+		m.highlightRow = true
+		return m
+	}
+	if view := load().View(); !strings.Contains(view, "\x1b[") {
+		t.Fatalf("the default theme rendered without color:\n%s", view)
+	}
+
+	t.Setenv("NO_COLOR", "")
+	m := load()
+	views := map[string]string{
+		"list":   m.View(),
+		"filter": press(m, "/", "b", "i").View(),
+		"help":   press(m, "?").View(),
+		"info":   press(m, "i").View(),
+	}
+	for name, view := range views {
+		if strings.Contains(view, "\x1b[") {
+			t.Errorf("%s view has escape sequences under NO_COLOR:\n%q", name, view)
+		}
+	}
+}
+
+func TestFilterModeTypesKeys(t *testing.T) {
+	m := press(testModel(t, testBranches...), "/")
+	if !m.filterMode {
+		t.Fatal("/ didn't start filter mode")
+	}
+
+	for _, k := range []string{"q", "j", "k", "/", "d"} {
+		next, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)})
+		m = next.(model)
+		if cmd != nil {
+			t.Errorf("typing %q returned a command", k)
+		}
+	}
+	if m.filterText != "qjk/d" {
+		t.Errorf("filterText = %q, want %q", m.filterText, "qjk/d")
+	}
+	if !m.filterMode || m.confirm != confirmNone || m.cursor != 0 {
+		t.Errorf("typing ran an action: filterMode %v, confirm %v, cursor %d", m.filterMode, m.confirm, m.cursor)
+	}
+
+	// ctrl+a moves the caret to the start instead of typing "ctrl+a"
+	m = press(m, "ctrl+a", "x")
+	if m.filterText != "xqjk/d" {
+		t.Errorf("filterText after ctrl+a x = %q, want %q", m.filterText, "xqjk/d")
+	}
+
+	if _, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlC}); cmd == nil || cmd() != tea.Quit() {
+		t.Error("ctrl+c didn't quit from filter mode")
+	}
+}
+
+func TestClearFilterRestoresCursor(t *testing.T) {
+	m := testModel(t, testBranches...)
+
+	m = press(m, "/", "i", "enter", "j")
+	want := m.highlighted()
+	m = press(m, "esc")
+	if m.filterText != "" || len(m.branches) != len(testBranches) {
+		t.Fatalf("esc didn't clear the filter %q", m.filterText)
+	}
+	if got := m.highlighted(); got != want {
+		t.Errorf("after clearing, highlighted %q, want %q", got, want)
+	}
+
+	m = press(m, "/", "r", "e", "l", "enter", "esc")
+	if got := m.highlighted(); got != "release/1.0" {
+		t.Errorf("after a second filter, highlighted %q, want release/1.0", got)
+	}
+
+	// Nothing was highlighted while the filter matched nothing
+	m = press(m, "/", "z", "z", "esc")
+	if m.cursor != 0 {
+		t.Errorf("after a filter without matches, cursor = %d, want 0", m.cursor)
+	}
+}
+
+func TestFilterOrder(t *testing.T) {
+	// Listed oldest first, as with --sort date
+	branches := []branchInfo{
+		{name: "fa-hotfix", committed: 100},
+		{name: "feature/a1", committed: 200},
+		{name: "feature/a3", committed: 400},
+		{name: "feature/a2", committed: 300},
+	}
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		// The list's own order
+		{"", []string{"fa-hotfix", "feature/a1", "feature/a3", "feature/a2"}},
+		// Equal scores, so the most recent first
+		{"feat", []string{"feature/a3", "feature/a2", "feature/a1"}},
+		{"ure a", []string{"feature/a3", "feature/a2", "feature/a1"}},
+		// A prefix match beats recency
+		{"fa", []string{"fa-hotfix", "feature/a3", "feature/a2", "feature/a1"}},
+	}
+	for _, tt := range tests {
+		m := testModel(t, branches...)
+		m.filterText = tt.filter
+		m.applyFilter()
+		if got := names(m.branches); !slices.Equal(got, tt.want) {
+			t.Errorf("filter %q = %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeLayers(t *testing.T) {
+	// In the order esc closes them
+	layers := []struct {
+		name string
+		open func(m *model)
+		shut func(m model) bool
+	}{
+		{"prompt", func(m *model) { m.prompt = promptNewBranch }, func(m model) bool { return m.prompt == promptNone }},
+		{"confirm", func(m *model) { m.confirm = confirmDelete }, func(m model) bool { return m.confirm == confirmNone }},
+		{"help", func(m *model) { m.showHelp = true }, func(m model) bool { return !m.showHelp }},
+		{"info", func(m *model) { m.info = &branchDetails{} }, func(m model) bool { return m.info == nil }},
+		{"filter", func(m *model) {
+			m.filterText, m.filteredApplied = "i", true
+			m.applyFilter()
+		}, func(m model) bool {
+			return m.filterText == "" && !m.filteredApplied && len(m.branches) == len(testBranches)
+		}},
+		{"count", func(m *model) { m.count = 3 }, func(m model) bool { return m.count == 0 }},
+	}
+
+	esc := func(m model) (model, tea.Cmd) {
+		next, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+		return next.(model), cmd
+	}
+
+	// Each layer on its own closes without quitting
+	for _, layer := range layers {
+		m := testModel(t, testBranches...)
+		layer.open(&m)
+		m, cmd := esc(m)
+		if cmd != nil {
+			t.Errorf("esc with only the %s open returned a command", layer.name)
+		}
+		if !layer.shut(m) {
+			t.Errorf("esc didn't close the %s", layer.name)
+		}
+	}
+
+	// With all of them open, the topmost closes first
+	m := testModel(t, testBranches...)
+	for _, layer := range layers {
+		layer.open(&m)
+	}
+	for i, layer := range layers {
+		var cmd tea.Cmd
+		m, cmd = esc(m)
+		if cmd != nil {
+			t.Fatalf("esc quit with the %s open", layer.name)
+		}
+		if !layer.shut(m) {
+			t.Errorf("esc %d didn't close the %s", i+1, layer.name)
+		}
+		for _, below := range layers[i+1:] {
+			if below.shut(m) {
+				t.Errorf("esc %d closed the %s along with the %s", i+1, below.name, layer.name)
+			}
+		}
+	}
+	if _, cmd := esc(m); cmd == nil || cmd() != tea.Quit() {
+		t.Error("esc with nothing open didn't quit")
+	}
+}
+
+func TestEmptyList(t *testing.T) {
+	keys := defaultKeyMap()
+	for _, action := range keyActions {
+		for _, key := range *keys.lookup(action) {
+			t.Run(action+" "+key, func(t *testing.T) {
+				m := testModel(t)
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%q on an empty list panicked: %v", key, r)
+					}
+				}()
+				m = press(m, key)
+				_ = m.View()
+				// Answering whatever the key may have asked
+				m = press(m, "y", "enter")
+				_ = m.View()
+			})
+		}
+	}
+}
+
+func TestDeleteMarkedWithoutMatches(t *testing.T) {
+	m := testModel(t, testBranches...)
+	m = press(m, " ", "/", "z", "z", "z", "enter", "d")
+	if m.confirm != confirmNone {
+		t.Errorf("d asked to delete branches the empty list hides: confirm %v", m.confirm)
+	}
+}
+
+// manyRemotes returns fake git output for n remotes sharing the same
+// perRemote branches, most recent first, plus those branches locally.
+func manyRemotes(n, perRemote int) *fakeGit {
+	var remotes, refs []string
+	stamp := n * perRemote * 2
+	for r := 0; r < n; r++ {
+		remote := fmt.Sprintf("fork%d", r)
+		if r%10 == 0 {
+			remote = fmt.Sprintf("team/fork%d", r)
+		}
+		remotes = append(remotes, remote)
+		for i := 0; i < perRemote; i++ {
+			name := fmt.Sprintf("%s/feature/TICKET-%d-some-work", remote, i)
+			refs = append(refs, refLine("refs/remotes/"+name, name, "2 days ago", "", "Ada", "<ada@example.com>", fmt.Sprint(stamp), "Work"))
+			stamp--
+		}
+	}
+	for i := 0; i < perRemote; i++ {
+		name := fmt.Sprintf("feature/TICKET-%d-some-work", i)
+		refs = append(refs, refLine("refs/heads/"+name, name, "3 days ago", "", "Ada", "<ada@example.com>", fmt.Sprint(stamp), "Work"))
+		stamp--
+	}
+	return &fakeGit{output: map[string]string{
+		"remote":       strings.Join(remotes, "\n"),
+		"for-each-ref": strings.Join(refs, "\n"),
+	}}
+}
+
+func BenchmarkGetRecentBranches(b *testing.B) {
+	// 6,100 refs
+	saved := git
+	git = manyRemotes(60, 100)
+	b.Cleanup(func() { git = saved })
+
+	q := branchQuery{all: true, sort: "-date", dates: "relative"}
+	for i := 0; i < b.N; i++ {
+		if _, err := getRecentBranches(q); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFilterManyRemotes(b *testing.B) {
+	saved := git
+	git = manyRemotes(60, 100)
+	b.Cleanup(func() { git = saved })
+
+	branches, err := getRecentBranches(branchQuery{remote: true, sort: "-date", dates: "relative"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	next, _ := initialModel(defaultConfig()).Update(branchesLoadedMsg{branches: branches})
+	next, _ = next.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m := next.(model)
+
+	// Typing a query a key at a time, redrawing after each
+	query := "fork3 ticket 42"
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for n := 1; n <= len(query); n++ {
+			m.filterText = query[:n]
+			m.applyFilter()
+			_ = m.View()
+		}
+	}
+}
+
+func BenchmarkFoldedNames(b *testing.B) {
+	saved := git
+	git = &fakeGit{}
+	b.Cleanup(func() { git = saved })
+
+	var branches []branchInfo
+	for i := 0; i < 3000; i++ {
+		branches = append(branches, branchInfo{name: fmt.Sprintf("Feature/TICKET-%d-Some-Work", i), author: "Ada Lovelace"})
+	}
+	m := initialModel(defaultConfig())
+	m.setBranches(branches)
+
+	// One keystroke's worth of matching, against names lowercased once
+	// when the list was loaded or again for every keystroke
+	match := func(b *testing.B, fold func(branchInfo) string) {
+		for i := 0; i < b.N; i++ {
+			for _, branch := range m.allBranches {
+				fuzzyScore(fold(branch), "ticket42")
+			}
+		}
+	}
+	b.Run("precomputed", func(b *testing.B) {
+		match(b, m.foldedField)
+	})
+	b.Run("per keystroke", func(b *testing.B) {
+		match(b, func(branch branchInfo) string { return strings.ToLower(branch.name) })
+	})
+}
+
+func TestDeleteConfirmFollowsBranch(t *testing.T) {
+	reload := func(m model, branches ...branchInfo) model {
+		next, _ := m.Update(branchesLoadedMsg{branches: slices.Clone(branches)})
+		return next.(model)
+	}
+
+	// A new branch pushes the confirmed one down a row
+	m := press(testModel(t, testBranches...), "j", "d")
+	f := &fakeGit{}
+	useGit(t, f)
+	m = reload(m, append([]branchInfo{{name: "newer", committed: 500}}, testBranches...)...)
+	m = press(m, "y")
+	if !slices.Contains(f.ran, "branch -d feature/Billing") || slices.Contains(f.ran, "branch -d main") {
+		t.Errorf("y after a reload ran %q, want it to delete feature/Billing", f.ran)
+	}
+
+	// A fetch pruned it
+	m = press(testModel(t, testBranches...), "j", "d")
+	m = reload(m, testBranches[0], testBranches[2], testBranches[3])
+	if m.confirm != confirmNone {
+		t.Errorf("the confirmation stayed open for a branch that is gone: confirm %v", m.confirm)
+	}
+
+	// The reload emptied the list
+	m = press(testModel(t, testBranches...), "d")
+	m = reload(m)
+	m = press(m, "y")
+	_ = m.View()
+}
+
+func TestCtrlCWaitsForCheckout(t *testing.T) {
+	m := testModel(t, testBranches...)
+	m.checkingOut, m.selected = true, true
+	next, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlC})
+	if cmd != nil || !next.(model).selected {
+		t.Error("ctrl+c quit while the checkout was running")
+	}
+}
+
+func TestDryRunNewBranch(t *testing.T) {
+	m := testModel(t, testBranches...)
+	m.dryRun = true
+	f := &fakeGit{fail: map[string]string{"show-ref": ""}}
+	useGit(t, f)
+
+	next, cmd := press(m, "n", "t", "r", "y").Update(keyMsg("enter"))
+	m = next.(model)
+	if m.created != "try" || cmd == nil {
+		t.Errorf("enter didn't finish with the branch to describe: created %q", m.created)
+	}
+	for _, line := range f.ran {
+		if strings.HasPrefix(line, "checkout") {
+			t.Errorf("a dry run ran git %s", line)
+		}
+	}
+}
+
+func TestGroupPosition(t *testing.T) {
+	branches := []branchInfo{
+		{name: "feature/a"}, {name: "feature/b"}, {name: "bugfix/c"}, {name: "main"},
+	}
+	m := testModel(t, branches...)
+	m.group = true
+	m.applyFilter()
+	if len(m.branches) == len(branches) {
+		t.Fatalf("no group headers in %q", names(m.branches))
+	}
+
+	var got []int
+	for m.cursor = range m.branches {
+		got = append(got, m.position())
+		if view := m.View(); !strings.Contains(view, fmt.Sprintf("%d/4", m.position())) {
+			t.Errorf("footer doesn't show %d/4:\n%s", m.position(), view)
+		}
+	}
+	// Headers take the position of their group's first branch
+	if !slices.IsSorted(got) || got[0] != 1 || got[len(got)-1] != 4 {
+		t.Errorf("positions = %v, want 1 up to 4", got)
+	}
+}
+
+func TestRenameKeepsMark(t *testing.T) {
+	m := testModel(t, testBranches...)
+	m = press(m, "j", " ")
+	m.renameBranch("feature/Billing", "feature/invoices")
+	if m.marked["feature/Billing"] || !m.marked["feature/invoices"] {
+		t.Errorf("marked = %v, want the mark on the new name", m.marked)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// hiddenFlags are left out of --help and the man page.
+var hiddenFlags = map[string]bool{"gen-man": true}
+
+// printUsageFlags is flag.PrintDefaults without the hidden flags.
+func printUsageFlags(out io.Writer) {
+	shown := flag.NewFlagSet("", flag.ContinueOnError)
+	shown.SetOutput(out)
+	flag.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] {
+			return
+		}
+		shown.Var(f.Value, f.Name, f.Usage)
+		shown.Lookup(f.Name).DefValue = f.DefValue
+	})
+	shown.PrintDefaults()
+}
+
+// wantsManPage reports whether args ask for --gen-man. It is checked
+// before the flags are defined, whose defaults would otherwise come from
+// the config file and environment.
+func wantsManPage(args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "gen-man" {
+			continue
+		}
+		on, err := strconv.ParseBool(value)
+		return !hasValue || (err == nil && on)
+	}
+	return false
+}
+
+// roff escapes text for a man page: backslashes, dashes, and dots or
+// quotes that would otherwise start a request at the beginning of a line.
+func roff(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// writeManPage writes a git-recent(1) man page in roff, built from the
+// registered flags and the default key bindings so it can't drift from
+// them.
+func writeManPage(w io.Writer) {
+	fmt.Fprintf(w, ".TH GIT\\-RECENT 1 \"\" \"git-recent %s\" \"User Commands\"\n", roff(version))
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintln(w, `git\-recent \- check out recently active git branches from an interactive menu`)
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	fmt.Fprintln(w, `.B git\-recent`)
+	fmt.Fprintln(w, `[\fIflags\fR]`)
+	fmt.Fprintln(w, ".SH DESCRIPTION")
+	fmt.Fprintln(w, "Lists the branches of the current repository, most recently committed to first, and checks out the one you pick. Type to filter the list, and use the keys below to move around and act on branches.")
+	fmt.Fprintln(w, "When stdin or stdout isn't a terminal, the branch names are printed one per line instead.")
+
+	fmt.Fprintln(w, ".SH OPTIONS")
+	flag.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] {
+			return
+		}
+		arg, usage := flag.UnquoteUsage(f)
+		fmt.Fprintln(w, ".TP")
+		if arg != "" {
+			fmt.Fprintf(w, ".BI \\-%s \" %s\"\n", roff(f.Name), roff(arg))
+		} else {
+			fmt.Fprintf(w, ".B \\-%s\n", roff(f.Name))
+		}
+		switch f.DefValue {
+		case "", "false", "0", "0s", "[]":
+		default:
+			usage += fmt.Sprintf(" (default %s)", f.DefValue)
+		}
+		fmt.Fprintln(w, roff(usage))
+	})
+
+	fmt.Fprintln(w, ".SH KEYS")
+	keys := defaultKeyMap()
+	for _, section := range helpSections {
+		fmt.Fprintf(w, ".SS %s\n", roff(section.title))
+		for _, k := range section.keys {
+			fmt.Fprintln(w, ".TP")
+			fmt.Fprintf(w, ".B %s\n", roff(k.label(keys)))
+			fmt.Fprintln(w, roff(k.desc))
+		}
+	}
+
+	fmt.Fprintln(w, ".SH EXIT STATUS")
+	for _, status := range []struct {
+		code int
+		desc string
+	}{
+		{exitOK, "a branch was checked out, created or selected"},
+		{exitError, "an error occurred"},
+		{exitCancelled, "quit without choosing a branch"},
+	} {
+		fmt.Fprintln(w, ".TP")
+		fmt.Fprintf(w, ".B %d\n", status.code)
+		fmt.Fprintln(w, roff(status.desc))
+	}
+
+	fmt.Fprintln(w, ".SH ENVIRONMENT")
+	for _, env := range [][2]string{
+		{"NO_COLOR", "turn off all colors and styling"},
+		{"GIT_RECENT_DEBUG", "log every git command, like --debug"},
+		{"GIT_RECENT_GIT", "the git executable to run, like --git-path"},
+		{"XDG_CONFIG_HOME", "where to look for git-recent/config.toml instead of ~/.config"},
+	} {
+		fmt.Fprintln(w, ".TP")
+		fmt.Fprintf(w, ".B %s\n", roff(env[0]))
+		fmt.Fprintln(w, roff(env[1]))
+	}
+
+	fmt.Fprintln(w, ".SH FILES")
+	fmt.Fprintln(w, ".TP")
+	fmt.Fprintln(w, ".I ~/.config/git\\-recent/config.toml")
+	fmt.Fprintln(w, "Defaults for the flags, pinned branches and key bindings. Flags override it.")
+	fmt.Fprintln(w, ".SH SEE ALSO")
+	fmt.Fprintln(w, ".BR git\\-checkout (1),")
+	fmt.Fprintln(w, ".BR git\\-for\\-each\\-ref (1)")
+}
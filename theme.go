@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"sort"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// theme holds every style View uses, so palettes can be swapped as a whole.
+type theme struct {
+	cursor          lipgloss.Style // the › marker on the highlighted row
+	selected        lipgloss.Style // the highlighted branch name
+	current         lipgloss.Style // the checked out branch and its * marker
+	currentSelected lipgloss.Style // the checked out branch when highlighted
+	remote          lipgloss.Style // remote-only branches in --all mode
+	dim             lipgloss.Style // counts and other secondary text
+	fresh           lipgloss.Style // dates of branches committed to recently
+	aging           lipgloss.Style // dates of branches left for weeks
+	stale           lipgloss.Style // dates of branches left for months
+	match           lipgloss.Style // the characters a filter matched, on top of the row's style
+	row             lipgloss.Style // the whole highlighted row, with highlight_row
+	cursorMark      string         // marks the highlighted row
+	checkMark       string         // marks rows picked for a batch delete
+}
+
+// themes returns the built-in color themes. Their styles are made on each
+// call, so they use the renderer main sets up rather than the one lipgloss
+// starts with.
+func themes() map[string]theme {
+	return map[string]theme{
+		"default": {
+			cursor:          lipgloss.NewStyle().Foreground(lipgloss.Color("205")),
+			selected:        lipgloss.NewStyle().Foreground(lipgloss.Color("205")).Bold(true),
+			current:         lipgloss.NewStyle().Foreground(lipgloss.Color("42")),
+			currentSelected: lipgloss.NewStyle().Foreground(lipgloss.Color("42")).Bold(true),
+			remote:          lipgloss.NewStyle().Foreground(lipgloss.Color("39")),
+			dim:             lipgloss.NewStyle().Foreground(lipgloss.Color("241")),
+			fresh:           lipgloss.NewStyle().Foreground(lipgloss.Color("42")),
+			aging:           lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+			stale:           lipgloss.NewStyle().Foreground(lipgloss.Color("203")),
+			match:           lipgloss.NewStyle().Bold(true).Underline(true),
+			row:             lipgloss.NewStyle().Foreground(lipgloss.Color("205")).Background(lipgloss.Color("236")).Bold(true),
+			cursorMark:      "›",
+			checkMark:       "✓",
+		},
+		"mono": {
+			cursor:          lipgloss.NewStyle().Bold(true),
+			selected:        lipgloss.NewStyle().Bold(true).Underline(true),
+			current:         lipgloss.NewStyle().Bold(true),
+			currentSelected: lipgloss.NewStyle().Bold(true).Underline(true),
+			remote:          lipgloss.NewStyle().Italic(true),
+			dim:             lipgloss.NewStyle().Faint(true),
+			fresh:           lipgloss.NewStyle().Faint(true),
+			aging:           lipgloss.NewStyle().Faint(true),
+			stale:           lipgloss.NewStyle().Faint(true),
+			match:           lipgloss.NewStyle().Reverse(true),
+			row:             lipgloss.NewStyle().Reverse(true),
+			cursorMark:      "›",
+			checkMark:       "✓",
+		},
+		"solarized": {
+			cursor:          lipgloss.NewStyle().Foreground(lipgloss.Color("#d33682")),
+			selected:        lipgloss.NewStyle().Foreground(lipgloss.Color("#d33682")).Bold(true),
+			current:         lipgloss.NewStyle().Foreground(lipgloss.Color("#859900")),
+			currentSelected: lipgloss.NewStyle().Foreground(lipgloss.Color("#859900")).Bold(true),
+			remote:          lipgloss.NewStyle().Foreground(lipgloss.Color("#268bd2")),
+			dim:             lipgloss.NewStyle().Foreground(lipgloss.Color("#586e75")),
+			fresh:           lipgloss.NewStyle().Foreground(lipgloss.Color("#859900")),
+			aging:           lipgloss.NewStyle().Foreground(lipgloss.Color("#b58900")),
+			stale:           lipgloss.NewStyle().Foreground(lipgloss.Color("#dc322f")),
+			match:           lipgloss.NewStyle().Bold(true).Underline(true),
+			row:             lipgloss.NewStyle().Foreground(lipgloss.Color("#d33682")).Background(lipgloss.Color("#073642")).Bold(true),
+			cursorMark:      "›",
+			checkMark:       "✓",
+		},
+	}
+}
+
+// plainTheme applies no styling at all and sticks to ASCII markers, so
+// output stays readable when piped or in terminals without color.
+func plainTheme() theme {
+	return theme{
+		cursor:          lipgloss.NewStyle(),
+		selected:        lipgloss.NewStyle(),
+		current:         lipgloss.NewStyle(),
+		currentSelected: lipgloss.NewStyle(),
+		remote:          lipgloss.NewStyle(),
+		dim:             lipgloss.NewStyle(),
+		fresh:           lipgloss.NewStyle(),
+		aging:           lipgloss.NewStyle(),
+		stale:           lipgloss.NewStyle(),
+		match:           lipgloss.NewStyle(),
+		row:             lipgloss.NewStyle(),
+		cursorMark:      ">",
+		checkMark:       "x",
+	}
+}
+
+// themeNames returns the available theme names, sorted.
+func themeNames() []string {
+	var names []string
+	for name := range themes() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectTheme returns the named theme, or the plain theme when NO_COLOR is
+// set (https://no-color.org).
+func selectTheme(name string) theme {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return plainTheme()
+	}
+	if t, ok := themes()[name]; ok {
+		return t
+	}
+	return themes()["default"]
+}
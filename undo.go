@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// deletedBranch is a branch deleted from the picker, kept so u can bring
+// it back.
+type deletedBranch struct {
+	name   string
+	remote bool   // a remote-tracking ref rather than a local branch
+	tip    string // the commit it pointed at
+}
+
+// fullRef returns the ref a branch of the list is stored under.
+func fullRef(name string, remote bool) string {
+	if remote {
+		return "refs/remotes/" + name
+	}
+	return "refs/heads/" + name
+}
+
+// branchTip returns the commit a branch points at.
+func branchTip(name string, remote bool) (string, error) {
+	output, err := git.Output("rev-parse", "--verify", "--quiet", fullRef(name, remote))
+	if err != nil {
+		return "", fmt.Errorf("couldn't find the tip of %s", name)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// restoreBranch recreates a deleted branch at its old tip. Its upstream
+// setting was removed along with it and isn't restored.
+func restoreBranch(d deletedBranch) error {
+	if d.remote {
+		return runGit("update-ref", fullRef(d.name, true), d.tip)
+	}
+	return runGit("branch", d.name, d.tip)
+}
+
+// rememberDeleted records a deleted branch for undoing.
+func (m *model) rememberDeleted(name string, remote bool, tip string) {
+	m.deleted = append(m.deleted, deletedBranch{name: name, remote: remote, tip: tip})
+}
+
+// undoHint is added to the status after a delete.
+func (m model) undoHint() string {
+	return fmt.Sprintf(" (%s to undo)", m.keys.undo.hint())
+}
+
+// undoDelete restores the most recently deleted branch and reloads the
+// list to show it.
+func (m *model) undoDelete() tea.Cmd {
+	if len(m.deleted) == 0 {
+		m.status = "Nothing to undo"
+		return nil
+	}
+	d := m.deleted[len(m.deleted)-1]
+	if err := restoreBranch(d); err != nil {
+		m.status = firstLine(err.Error())
+		return nil
+	}
+	m.deleted = m.deleted[:len(m.deleted)-1]
+	m.status = fmt.Sprintf("Restored %s at %s", d.name, d.tip[:min(7, len(d.tip))])
+	if len(m.deleted) > 0 {
+		m.status += m.undoHint()
+	}
+	return m.reload()
+}
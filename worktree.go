@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// worktreeResultMsg reports how adding a worktree went.
+type worktreeResultMsg struct {
+	err error
+}
+
+// worktreeBranch returns the local branch a worktree for b checks out: b
+// itself, or for a remote branch the local branch that tracks it. Tags
+// have none and are checked out detached.
+func (m model) worktreeBranch(b branchInfo) string {
+	if b.remote {
+		return localBranchFor(b.name, m.remotes)
+	}
+	return b.name
+}
+
+// worktreeDir derives the directory for a new worktree of branch: a
+// sibling of the repository named after the branch, with slashes turned
+// into dashes so feature/x doesn't nest.
+func worktreeDir(root, branch string) string {
+	return filepath.Join(filepath.Dir(root), strings.ReplaceAll(branch, "/", "-"))
+}
+
+// worktreeArgs returns the git arguments that add a worktree at dir for b.
+// Like checkoutArgs, a remote branch gets a local tracking branch unless
+// one exists already.
+func worktreeArgs(b branchInfo, local, dir string) []string {
+	switch {
+	case b.tag:
+		return []string{"worktree", "add", "--detach", dir, b.name}
+	case b.remote && !localBranchExists(local):
+		return []string{"worktree", "add", "--track", "-b", local, dir, b.name}
+	}
+	return []string{"worktree", "add", dir, local}
+}
+
+// addWorktreeCmd runs git worktree add in the background.
+func addWorktreeCmd(args []string) tea.Cmd {
+	return func() tea.Msg {
+		return worktreeResultMsg{err: runGit(args...)}
+	}
+}
+
+// chooseWorktree opens b in a worktree of its own instead of checking it
+// out here. A branch that already has a worktree, this one included, is
+// pointed at rather than added twice.
+func (m model) chooseWorktree(b branchInfo) (tea.Model, tea.Cmd) {
+	if m.selectOnly {
+		return m.chooseBranch(b)
+	}
+	m.chosen = b
+	local := m.worktreeBranch(b)
+	if !b.tag {
+		path, ok := m.worktrees[local]
+		if !ok && local == m.current {
+			path, ok = m.repoRoot, true
+		}
+		if ok {
+			m.selected = true
+			m.checkoutLog = []string{fmt.Sprintf("%s is already checked out at %s", local, path)}
+			return m, tea.Quit
+		}
+	}
+
+	m.worktreeDir = worktreeDir(m.repoRoot, local)
+	m.selected = true
+	if m.dryRun {
+		return m, tea.Quit
+	}
+	m.checkingOut = true
+	m.status = "Adding a worktree for " + b.name
+	return m, tea.Batch(addWorktreeCmd(worktreeArgs(b, local, m.worktreeDir)), m.spinner.Tick)
+}